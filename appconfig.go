@@ -0,0 +1,102 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+// WithAppConfig makes the factory hand out pools connected as the role of
+// config, e.g., the restricted role the application uses in production,
+// while templates are migrated and databases are created and owned by
+// the role of the factory config.
+//
+// The application role is granted to connect to ephemeral databases and
+// create temporary tables in them, and creating objects in the public schema
+// is revoked from PUBLIC in the template, as on PostgreSQL 15+. Default
+// privileges of the migrating role are set up in the template before running
+// the migrator, as is usual in production, so that the application role can
+// read and write tables (SELECT, INSERT, UPDATE, DELETE), use sequences
+// (USAGE, SELECT, UPDATE) and execute functions created by migrations. Any
+// other privileges, e.g., TRUNCATE, or USAGE on schemas other than public,
+// must be granted by migrations, so tests catch missing GRANTs. The role must
+// exist, see WithRoles.
+//
+// Only the connection settings of config are used, the database is replaced
+// by the ephemeral one.
+func WithAppConfig(config *pgxpool.Config) FactoryOption {
+	return func(options *factoryOptions) { options.appConfig = config.Copy() }
+}
+
+// appPrivilegesLineage is the lineage of migrators arranging privileges
+// of the application role, which doesn't depend on the role, so that
+// chaining them keeps the lineage of the migrator they precede.
+const appPrivilegesLineage = "app-privileges"
+
+// appPrivilegesMigrator revokes creating objects in the public schema from
+// PUBLIC, which is granted by default on servers older than PostgreSQL 15,
+// and sets up default privileges of the migrating role for the app role.
+func appPrivilegesMigrator(app string) *migrators.FuncMigrator {
+	role := pgx.Identifier{app}.Sanitize()
+	stmts := []string{
+		"REVOKE CREATE ON SCHEMA public FROM PUBLIC",
+		"ALTER DEFAULT PRIVILEGES GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO " + role,
+		"ALTER DEFAULT PRIVILEGES GRANT USAGE, SELECT, UPDATE ON SEQUENCES TO " + role,
+		"ALTER DEFAULT PRIVILEGES GRANT EXECUTE ON FUNCTIONS TO " + role,
+	}
+
+	migrate := func(ctx context.Context, conn *pgx.Conn) error {
+		for _, stmt := range stmts {
+			if _, err := conn.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("pgxephemeraltest: failed to set app role privileges: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	return migrators.Func("app-privileges:"+app, migrate).WithLineage(appPrivilegesLineage)
+}
+
+// withAppPrivileges returns the migrator preceded by arranging privileges
+// of the application role set by options, if any. The migrator may be nil,
+// in which case only the privileges are arranged.
+func (p *factoryOptions) withAppPrivileges(migrator Migrator) Migrator {
+	if p.appConfig == nil {
+		return migrator
+	}
+
+	privileges := appPrivilegesMigrator(p.appConfig.ConnConfig.User)
+	if migrator == nil {
+		return privileges
+	}
+
+	return migrators.Chain(privileges, migrator)
+}
+
+// grantApp grants the application role set by options access to the db
+// database.
+func (f *PoolFactory) grantApp(ctx context.Context, db string) error {
+	if f.options.appConfig == nil || f.options.appConfig.ConnConfig.User == f.config.ConnConfig.User {
+		return nil
+	}
+
+	if err := f.m.GrantConnect(ctx, db, f.options.appConfig.ConnConfig.User); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to grant access to application role: %w", err)
+	}
+
+	return nil
+}
+
+// poolConfig returns the config of pools handed out by the factory.
+func (f *PoolFactory) poolConfig() *pgxpool.Config {
+	if f.options.appConfig != nil {
+		return f.options.appConfig.Copy()
+	}
+
+	return f.config.Copy()
+}
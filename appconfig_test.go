@@ -0,0 +1,117 @@
+package pgxephemeraltest_test
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestWithAppConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it runs tests as the application role", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		app := "it_app_" + strconv.FormatUint(rand.Uint64(), 36) // #nosec G404
+
+		appConfig := config.Copy()
+		appConfig.ConnConfig.User = app
+		appConfig.ConnConfig.Password = "app-secret"
+
+		migrator := testutil.NewMigrator(`
+CREATE TABLE defaulted (id INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY);
+CREATE SCHEMA private;
+CREATE TABLE private.forgotten (id INT PRIMARY KEY);`, "app-"+app)
+
+		role := pgxephemeraltest.RoleSpec{Name: app, Login: true, Password: "app-secret"}
+
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithRoles(role),
+			pgxephemeraltest.WithAppConfig(appConfig),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+		testutil.DropOnCleanup(t, config, []string{f.Template()}, app)
+
+		// Act
+		pool := f.Pool(t)
+
+		// Assert
+		var user string
+
+		err = pool.QueryRow(t.Context(), "SELECT current_user").Scan(&user)
+		require.NoError(t, err)
+		assert.Equal(t, app, user)
+
+		_, err = pool.Exec(t.Context(), "INSERT INTO defaulted DEFAULT VALUES")
+		require.NoError(t, err, "default privileges must cover tables and sequences")
+
+		for _, sql := range []string{"SELECT * FROM private.forgotten", "TRUNCATE defaulted"} {
+			_, err = pool.Exec(t.Context(), sql)
+
+			var pgErr *pgconn.PgError
+			require.True(t, errors.As(err, &pgErr), "expected permission error for %q, got %v", sql, err)
+			assert.Equal(t, "42501", pgErr.Code, sql)
+		}
+	})
+
+	t.Run("it arranges privileges of the application role in upgrade templates", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		app := "it_app_" + strconv.FormatUint(rand.Uint64(), 36) // #nosec G404
+
+		appConfig := config.Copy()
+		appConfig.ConnConfig.User = app
+		appConfig.ConnConfig.Password = "app-secret"
+
+		steps := []pgxephemeraltest.Migrator{
+			testutil.NewMigrator(
+				"CREATE TABLE granted (id INT PRIMARY KEY); GRANT SELECT ON granted TO "+app,
+				"upgrade-"+app,
+			),
+		}
+
+		role := pgxephemeraltest.RoleSpec{Name: app, Login: true, Password: "app-secret"}
+
+		f, err := pgxephemeraltest.NewUpgradeFactory(
+			t.Context(),
+			config,
+			steps,
+			pgxephemeraltest.WithRoles(role),
+			pgxephemeraltest.WithAppConfig(appConfig),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+		// Templates hold default privileges for the role, so they are
+		// dropped before it.
+		testutil.DropOnCleanup(t, config, []string{f.Version(0).Template(), f.Version(1).Template()}, app)
+
+		// Act
+		pool := f.Version(1).Pool(t)
+
+		// Assert
+		_, err = pool.Exec(t.Context(), "SELECT * FROM granted")
+		require.NoError(t, err)
+
+		_, err = pool.Exec(t.Context(), "CREATE TABLE created (id INT PRIMARY KEY)")
+
+		var pgErr *pgconn.PgError
+		require.True(t, errors.As(err, &pgErr), "expected permission error, got %v", err)
+		assert.Equal(t, "42501", pgErr.Code)
+	})
+}
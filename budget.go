@@ -0,0 +1,234 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/sync/semaphore"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+// WithMaxDatabases limits the number of ephemeral databases existing at
+// the same time. Pool blocks until a database of a finished test is dropped,
+// or the test context is done.
+//
+// Databases are released when the test using them is done, and databases
+// of a test outlive its subtests, so a test together with its parent tests
+// cannot request more databases than the limit. Pool fails such a test right
+// away instead of waiting for the test itself or its parents.
+//
+// Combined with WithMaxTotalConns, the connections are split evenly between
+// the databases, that is, MaxConns of each pool is at most n-th of
+// the total.
+func WithMaxDatabases(n int) FactoryOption {
+	return func(config *factoryOptions) { config.maxDatabases = n }
+}
+
+// WithMaxTotalConns limits the total number of connections of pools handed
+// out by the factory. Each pool reserves MaxConns connections of the budget
+// for its lifetime, and Pool blocks until enough connections are released,
+// or the test context is done. MaxConns of pools is capped by the budget.
+//
+// Pools keep the configured MaxConns unless WithMaxDatabases is set, so
+// the number of pools existing at the same time is n / MaxConns. If MaxConns
+// is close to n, tests effectively run one at a time; lower MaxConns of
+// the config or set WithMaxDatabases to split the budget between more pools.
+//
+// Maintenance connections are limited separately, see WithMaintenanceConns.
+func WithMaxTotalConns(n int) FactoryOption {
+	return func(config *factoryOptions) { config.maxTotalConns = n }
+}
+
+// WithServerConnBudget makes the factory derive the connection budget of
+// WithMaxTotalConns, unless set explicitly, from the connections the server
+// can accept on factory creation: max_connections less reserved connection
+// slots, connected sessions and maintenance connections of the factory.
+//
+// The budget is not shared with other processes, e.g., test binaries of
+// other packages run in parallel by go test, so it is best combined with
+// go test -p 1 or an explicit WithMaxTotalConns per package.
+func WithServerConnBudget() FactoryOption {
+	return func(config *factoryOptions) { config.serverConnBudget = true }
+}
+
+// budget limits the databases and connections of pools handed out by
+// a factory.
+type budget struct {
+	dbs   *semaphore.Weighted // nil if unlimited
+	conns *semaphore.Weighted // nil if unlimited
+	// poolConns is MaxConns of each pool, zero to keep the configured one.
+	poolConns int32
+	// maxPools is the number of pools fitting the budget at the same time,
+	// zero if unlimited.
+	maxPools int
+
+	mu sync.Mutex
+	// held are the numbers of pools held by tests keyed by test name,
+	// which are released only when the test is done.
+	held map[string]int
+}
+
+func newBudget(
+	ctx context.Context,
+	m *dbmanager.DBManager,
+	config *pgxpool.Config,
+	options factoryOptions,
+) (*budget, error) {
+	//nolint:exhaustruct // unlimited unless set below.
+	b := budget{held: make(map[string]int)}
+
+	if options.maxDatabases > 0 {
+		b.dbs = semaphore.NewWeighted(int64(options.maxDatabases))
+		b.maxPools = options.maxDatabases
+	}
+
+	total := options.maxTotalConns
+	if total <= 0 && options.serverConnBudget {
+		available, err := m.AvailableConns(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to derive connection budget: %w", err)
+		}
+
+		total = max(available-int(options.maintenanceConns), 1)
+	}
+
+	if total <= 0 {
+		return &b, nil
+	}
+
+	poolConns := config.MaxConns
+	if options.appConfig != nil {
+		poolConns = options.appConfig.MaxConns
+	}
+
+	share := total
+	if options.maxDatabases > 0 {
+		share = max(total/options.maxDatabases, 1)
+	}
+
+	b.poolConns = int32(max(min(int(poolConns), share), 1)) //nolint:gosec // bounded by MaxConns.
+	b.conns = semaphore.NewWeighted(int64(total))
+
+	if pools := total / int(b.poolConns); b.maxPools == 0 || pools < b.maxPools {
+		b.maxPools = pools
+	}
+
+	return &b, nil
+}
+
+// acquire waits for capacity of a pool of the tb test and returns
+// the function releasing it.
+//
+// Capacity is released when the test is done, and capacity of a parent test
+// only after its subtests are done, so if the test and its parents already
+// hold all the pools the budget allows, it fails right away rather than
+// waiting for itself forever.
+func (b *budget) acquire(tb internaltesting.TB) (func(), error) {
+	if b.maxPools == 0 {
+		return b.wait(tb.Context())
+	}
+
+	name := tb.Name()
+	if err := b.hold(name); err != nil {
+		return nil, err
+	}
+
+	release, err := b.wait(tb.Context())
+	if err != nil {
+		b.unhold(name)
+
+		return nil, err
+	}
+
+	return func() {
+		release()
+		b.unhold(name)
+	}, nil
+}
+
+// hold records a pool held by the named test, unless the test and its
+// parents already hold all the pools the budget allows.
+func (b *budget) hold(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Subtest names are prefixed by the names of their parents.
+	var n int
+	for test := name; ; {
+		n += b.held[test]
+
+		i := strings.LastIndexByte(test, '/')
+		if i < 0 {
+			break
+		}
+
+		test = test[:i]
+	}
+
+	if n >= b.maxPools {
+		return fmt.Errorf(
+			"pgxephemeraltest: test and its parents already hold %d pools, as many as the factory budget "+
+				"allows at the same time, and would wait for themselves; "+
+				"raise WithMaxDatabases or WithMaxTotalConns",
+			n,
+		)
+	}
+
+	b.held[name]++
+
+	return nil
+}
+
+func (b *budget) unhold(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.held[name] <= 1 {
+		delete(b.held, name)
+	} else {
+		b.held[name]--
+	}
+}
+
+// wait waits for capacity of a pool and returns the function releasing it.
+func (b *budget) wait(ctx context.Context) (func(), error) {
+	if b.dbs != nil {
+		if err := b.dbs.Acquire(ctx, 1); err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to wait for database budget: %w", err)
+		}
+	}
+
+	if b.conns != nil {
+		if err := b.conns.Acquire(ctx, int64(b.poolConns)); err != nil {
+			if b.dbs != nil {
+				b.dbs.Release(1)
+			}
+
+			return nil, fmt.Errorf("pgxephemeraltest: failed to wait for connection budget: %w", err)
+		}
+	}
+
+	return func() {
+		if b.conns != nil {
+			b.conns.Release(int64(b.poolConns))
+		}
+
+		if b.dbs != nil {
+			b.dbs.Release(1)
+		}
+	}, nil
+}
+
+// size applies the connection budget to the config of a pool.
+func (b *budget) size(config *pgxpool.Config) {
+	if b.poolConns > 0 {
+		config.MaxConns = b.poolConns
+		config.MinConns = min(config.MinConns, b.poolConns)
+		config.MinIdleConns = min(config.MinIdleConns, b.poolConns)
+	}
+}
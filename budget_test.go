@@ -0,0 +1,200 @@
+package pgxephemeraltest_test
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestBudget(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it splits connections between databases", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		config.MaxConns = 8
+
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			testutil.NewKVMigrator(),
+			pgxephemeraltest.WithMaxDatabases(4),
+			pgxephemeraltest.WithMaxTotalConns(10),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Act
+		pool := f.Pool(t)
+
+		// Assert
+		assert.Equal(t, int32(2), pool.Config().MaxConns)
+		require.NoError(t, pool.Ping(t.Context()))
+	})
+
+	t.Run("it blocks until capacity is released", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			testutil.PoolConfig(t),
+			testutil.NewKVMigrator(),
+			pgxephemeraltest.WithMaxDatabases(1),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		first, firstCleanups := newBudgetTB(t, "first")
+		second, secondCleanups := newBudgetTB(t, "second")
+
+		f.Pool(first)
+
+		// Act
+		acquired := make(chan *pgxpool.Pool)
+
+		go func() { acquired <- f.Pool(second) }()
+
+		var blocked bool
+		select {
+		case <-acquired:
+		case <-time.After(100 * time.Millisecond):
+			blocked = true
+		}
+
+		runCleanups(*firstCleanups)
+
+		// Assert
+		assert.True(t, blocked, "second pool must wait for the first one")
+		require.NotNil(t, <-acquired)
+		runCleanups(*secondCleanups)
+	})
+
+	t.Run("it fails a test requesting more pools than the budget allows", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			testutil.PoolConfig(t),
+			testutil.NewKVMigrator(),
+			pgxephemeraltest.WithMaxDatabases(1),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		tt, cleanups := newBudgetTB(t, "test")
+
+		var fatal string
+
+		tt.EXPECT().Fatal(gomock.Any()).Times(1).Do(func(args ...any) {
+			fatal = fmt.Sprint(args...)
+			runtime.Goexit()
+		})
+
+		f.Pool(tt)
+
+		// Act
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			f.Pool(tt)
+		}()
+
+		// Assert
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("second pool of the test must not wait for the first one")
+		}
+
+		assert.Contains(t, fatal, "would wait for themselves")
+		runCleanups(*cleanups)
+	})
+
+	t.Run("it fails a subtest requesting pools held by its parent", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			testutil.PoolConfig(t),
+			testutil.NewKVMigrator(),
+			pgxephemeraltest.WithMaxDatabases(1),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		parent, parentCleanups := newBudgetTB(t, "parent")
+		child, childCleanups := newBudgetTB(t, "parent/child")
+
+		var fatal string
+
+		child.EXPECT().Fatal(gomock.Any()).Times(1).Do(func(args ...any) {
+			fatal = fmt.Sprint(args...)
+			runtime.Goexit()
+		})
+
+		f.Pool(parent)
+
+		// Act
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			f.Pool(child)
+		}()
+
+		// Assert
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("subtest must not wait for the pool of its parent")
+		}
+
+		assert.Contains(t, fatal, "would wait for themselves")
+		runCleanups(*childCleanups)
+		runCleanups(*parentCleanups)
+	})
+}
+
+// newBudgetTB returns a test double of the name subtest of t collecting
+// cleanups registered by Pool.
+func newBudgetTB(t *testing.T, name string) (*internaltesting.MockTB, *[]func()) {
+	t.Helper()
+
+	var (
+		cleanups []func()
+		tt       = internaltesting.NewMockTB(gomock.NewController(t))
+	)
+
+	tt.EXPECT().Context().AnyTimes().Return(t.Context())
+	tt.EXPECT().Name().AnyTimes().Return(t.Name() + "/" + name)
+	tt.EXPECT().Cleanup(gomock.Any()).AnyTimes().Do(func(f func()) { cleanups = append(cleanups, f) })
+	tt.EXPECT().Helper().AnyTimes()
+	tt.EXPECT().Logf(gomock.Any(), gomock.Any()).AnyTimes()
+	tt.EXPECT().Failed().AnyTimes().Return(false)
+
+	return tt, &cleanups
+}
+
+// runCleanups runs cleanups in reverse order, as testing.T does.
+func runCleanups(cleanups []func()) {
+	for _, f := range slices.Backward(cleanups) {
+		f()
+	}
+}
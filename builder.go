@@ -0,0 +1,514 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/querytag"
+)
+
+// maxParentDepth limits the depth of automatically created parent rows,
+// guarding against cycles of required foreign keys.
+const maxParentDepth = 16
+
+// buildSequences holds per-test sequences used to generate fake values.
+var buildSequences sync.Map //nolint:gochecknoglobals
+
+// buildSequence generates fake values of a test.
+type buildSequence struct {
+	n atomic.Int64
+	// prefix is unique to the test, so that text values generated by
+	// tests sharing a database, e.g., transactions of a TxFactory, don't
+	// collide in UNIQUE columns.
+	prefix string
+	// salt is unique to the test as prefix is, for values other than text.
+	salt uint32
+}
+
+// fakeEpoch is the base of generated date and time values.
+//
+//nolint:gochecknoglobals // constant value.
+var fakeEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// TableNamer is implemented by row types to name the table Build inserts into.
+type TableNamer interface {
+	TableName() string
+}
+
+// Builder builds a row of type T and inserts it into the table mapped to T.
+//
+// Struct fields are mapped to columns by the db tag, or by the snake_cased
+// field name. Fields tagged with db:"-" are skipped.
+type Builder[T any] struct {
+	tb   internaltesting.TB
+	q    Querier
+	mods []func(*T)
+}
+
+// Build returns a builder of T rows inserted using q.
+//
+// The table is named by the TableName method if T implements TableNamer.
+// Otherwise, the snake_cased type name is used, preferring its plural form,
+// e.g., rows of the BookAuthor type are inserted into book_authors, or
+// book_author if the former does not exist.
+//
+// Fields left with zero values are not inserted, so that column defaults
+// apply. Required columns, that is NOT NULL columns without a default,
+// are filled with fake values, and required foreign keys reference
+// automatically created parent rows. Text and UUID fake values are prefixed
+// with a value unique to the test, so that tests sharing a database don't
+// generate equal values. Required foreign keys with only some of their
+// columns set are rejected.
+func Build[T any](tb internaltesting.TB, q Querier) *Builder[T] {
+	tb.Helper()
+
+	return &Builder[T]{tb: tb, q: q, mods: nil}
+}
+
+// With returns a copy of the builder that applies fns to the row before
+// inserting it.
+func (b *Builder[T]) With(fns ...func(*T)) *Builder[T] {
+	mods := make([]func(*T), 0, len(b.mods)+len(fns))
+	mods = append(mods, b.mods...)
+	mods = append(mods, fns...)
+
+	return &Builder[T]{tb: b.tb, q: b.q, mods: mods}
+}
+
+// Insert inserts the row and returns it as stored in the database.
+// It fails the test on error.
+func (b *Builder[T]) Insert() T {
+	b.tb.Helper()
+
+	row, err := b.insert(b.tb.Context())
+	assertNoError(b.tb, err)
+
+	return row
+}
+
+// InsertN inserts n rows and returns them as stored in the database.
+// It fails the test on error.
+func (b *Builder[T]) InsertN(n int) []T {
+	b.tb.Helper()
+
+	rows := make([]T, n)
+	for i := range rows {
+		rows[i] = b.Insert()
+	}
+
+	return rows
+}
+
+func (b *Builder[T]) insert(ctx context.Context) (T, error) {
+	ctx = querytag.Internal(ctx)
+
+	var row T
+
+	for _, fn := range b.mods {
+		fn(&row)
+	}
+
+	v := reflect.ValueOf(row)
+	if v.Kind() != reflect.Struct {
+		return row, fmt.Errorf("pgxephemeraltest: failed to build row: %T is not a struct", row)
+	}
+
+	t, err := resolveBuildTable(ctx, b.q, &row)
+	if err != nil {
+		return row, fmt.Errorf("pgxephemeraltest: failed to build %T row: %w", row, err)
+	}
+
+	cols, err := catalog.Columns(ctx, b.q, t)
+	if err != nil {
+		return row, fmt.Errorf("pgxephemeraltest: failed to build %T row: %w", row, err)
+	}
+
+	known := make(map[string]struct{}, len(cols))
+	for _, c := range cols {
+		known[c.Name] = struct{}{}
+	}
+
+	set := make(map[string]any)
+
+	var returning []string
+
+	for _, f := range structFields(v.Type(), nil) {
+		if _, ok := known[f.column]; !ok {
+			continue
+		}
+
+		returning = append(returning, f.column)
+
+		if fv := v.FieldByIndex(f.index); !fv.IsZero() {
+			set[f.column] = fv.Interface()
+		}
+	}
+
+	if len(returning) == 0 {
+		return row, fmt.Errorf(
+			"pgxephemeraltest: failed to build %T row: no fields map to columns of %s",
+			row,
+			t.Key(),
+		)
+	}
+
+	g := rowGenerator{q: b.q, seq: buildSequenceFor(b.tb)}
+
+	if err := g.fill(ctx, t, cols, set, 0); err != nil {
+		return row, fmt.Errorf("pgxephemeraltest: failed to build %T row: %w", row, err)
+	}
+
+	rows, err := b.q.Query(ctx, insertSQL(t, set, catalog.QuoteIdentifiers(returning)), insertArgs(set)...)
+	if err != nil {
+		return row, fmt.Errorf("pgxephemeraltest: failed to insert %T row: %w", row, err)
+	}
+
+	row, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[T])
+	if err != nil {
+		return row, fmt.Errorf("pgxephemeraltest: failed to insert %T row: %w", row, err)
+	}
+
+	return row, nil
+}
+
+// buildSequenceFor returns the fake value sequence of the tb test.
+func buildSequenceFor(tb internaltesting.TB) *buildSequence {
+	tb.Helper()
+
+	if seq, ok := buildSequences.Load(tb); ok {
+		return seq.(*buildSequence) //nolint:forcetypeassert // only *buildSequence is stored.
+	}
+
+	salt := rand.Uint32() // #nosec G404 -- uniqueness, not security.
+
+	//nolint:exhaustruct // the counter starts at zero.
+	seq, loaded := buildSequences.LoadOrStore(tb, &buildSequence{
+		prefix: strconv.FormatUint(uint64(salt), 36),
+		salt:   salt,
+	})
+	if !loaded {
+		tb.Cleanup(func() { buildSequences.Delete(tb) })
+	}
+
+	return seq.(*buildSequence) //nolint:forcetypeassert // only *buildSequence is stored.
+}
+
+// resolveBuildTable resolves the table rows of type T are inserted into.
+func resolveBuildTable[T any](ctx context.Context, q Querier, row *T) (catalog.Table, error) {
+	// TableNamer is implemented either by T or by *T, the latter
+	// covers both.
+	if n, ok := any(row).(TableNamer); ok {
+		return catalog.ResolveTable(ctx, q, n.TableName()) //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	name := snakeCase(reflect.TypeFor[T]().Name())
+
+	t, err := catalog.ResolveTable(ctx, q, name+"s")
+	if errors.Is(err, catalog.ErrTableNotFound) {
+		return catalog.ResolveTable(ctx, q, name) //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	return t, err //nolint:wrapcheck // wrapped by the caller.
+}
+
+// structField is a struct field mapped to a column.
+type structField struct {
+	column string
+	index  []int
+}
+
+// structFields returns exported fields of typ mapped to columns, flattening
+// untagged embedded structs.
+func structFields(typ reflect.Type, index []int) []structField {
+	var fields []structField
+
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag, hasTag := f.Tag.Lookup("db")
+		tag, _, _ = strings.Cut(tag, ",")
+
+		if tag == "-" {
+			continue
+		}
+
+		fi := append(append([]int(nil), index...), i)
+
+		if f.Anonymous && !hasTag && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, structFields(f.Type, fi)...)
+			continue
+		}
+
+		if tag == "" {
+			tag = snakeCase(f.Name)
+		}
+
+		fields = append(fields, structField{column: tag, index: fi})
+	}
+
+	return fields
+}
+
+// snakeCase converts a Go identifier to snake case, e.g., AuthorID
+// to author_id.
+func snakeCase(s string) string {
+	runes := []rune(s)
+
+	var b strings.Builder
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
+
+// rowGenerator fills required columns of rows.
+type rowGenerator struct {
+	q   Querier
+	seq *buildSequence
+}
+
+// fill sets values of required columns missing from set, creating parent
+// rows for required foreign keys.
+func (g rowGenerator) fill(
+	ctx context.Context,
+	t catalog.Table,
+	cols []catalog.Column,
+	set map[string]any,
+	depth int,
+) error {
+	required := make(map[string]catalog.Column)
+
+	for _, c := range cols {
+		if _, ok := set[c.Name]; !ok && c.NotNull && !c.HasDefault && !c.Generated {
+			required[c.Name] = c
+		}
+	}
+
+	if len(required) == 0 {
+		return nil
+	}
+
+	fks, err := catalog.ForeignKeys(ctx, g.q, t)
+	if err != nil {
+		return err //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	for _, fk := range fks {
+		if !fkRequired(fk, required) {
+			continue
+		}
+
+		if partial := fkSet(fk, set); len(partial) > 0 {
+			return fmt.Errorf(
+				"foreign key columns %s of %s are only partly set, set all of them or none",
+				strings.Join(partial, ", "),
+				t.Key(),
+			)
+		}
+
+		if depth >= maxParentDepth {
+			return fmt.Errorf("required foreign keys of %s nest too deep, likely a cycle", t.Key())
+		}
+
+		parent, err := g.insertParent(ctx, fk.RefTable, depth+1)
+		if err != nil {
+			return fmt.Errorf("create parent %s row: %w", fk.RefTable.Key(), err)
+		}
+
+		for i, col := range fk.Columns {
+			set[col] = parent[fk.RefColumns[i]]
+			delete(required, col)
+		}
+	}
+
+	for _, c := range cols {
+		if _, ok := required[c.Name]; !ok {
+			continue
+		}
+
+		v, err := g.fake(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		set[c.Name] = v
+	}
+
+	return nil
+}
+
+// fkRequired reports whether any column of fk is required and not set.
+func fkRequired(fk catalog.ForeignKey, required map[string]catalog.Column) bool {
+	return slices.ContainsFunc(fk.Columns, func(col string) bool {
+		_, ok := required[col]
+		return ok
+	})
+}
+
+// fkSet returns the columns of fk set in set.
+func fkSet(fk catalog.ForeignKey, set map[string]any) []string {
+	return slices.DeleteFunc(slices.Clone(fk.Columns), func(col string) bool {
+		_, ok := set[col]
+		return !ok
+	})
+}
+
+// insertParent inserts a row with only the required columns set.
+func (g rowGenerator) insertParent(ctx context.Context, t catalog.Table, depth int) (Row, error) {
+	cols, err := catalog.Columns(ctx, g.q, t)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	set := make(map[string]any)
+	if err := g.fill(ctx, t, cols, set, depth); err != nil {
+		return nil, err
+	}
+
+	var raw string
+
+	err = g.q.QueryRow(ctx, insertSQL(t, set, "to_jsonb(t.*)::text"), insertArgs(set)...).Scan(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("insert row: %w", err)
+	}
+
+	return decodeRow(&raw)
+}
+
+// fake returns a deterministic fake value of the column in its text form.
+func (g rowGenerator) fake(ctx context.Context, c catalog.Column) (any, error) {
+	n := g.seq.n.Add(1)
+
+	switch c.Category {
+	case "B":
+		return "false", nil
+	case "N":
+		return strconv.FormatInt(n, 10), nil
+	case "S":
+		digits := strconv.FormatInt(n, 10)
+
+		s := c.Name + "-" + g.seq.prefix + "-" + digits
+		if c.MaxLength > 0 && len(s) > c.MaxLength {
+			// Keep as much of the prefix as fits, so that short values
+			// are still unlikely to collide with the ones of other tests.
+			s = g.seq.prefix[:min(len(g.seq.prefix), max(0, c.MaxLength-len(digits)))] + digits
+			s = s[max(0, len(s)-c.MaxLength):]
+		}
+
+		return s, nil
+	case "D":
+		ts := fakeEpoch.Add(time.Duration(n) * time.Second)
+
+		switch {
+		case c.Type == "date":
+			return fakeEpoch.AddDate(0, 0, int(n)).Format(time.DateOnly), nil
+		case strings.HasPrefix(c.Type, "time with"):
+			return ts.Format(time.TimeOnly) + "+00", nil
+		case strings.HasPrefix(c.Type, "time"):
+			if strings.HasPrefix(c.Type, "timestamp") {
+				return ts.Format(time.RFC3339), nil
+			}
+
+			return ts.Format(time.TimeOnly), nil
+		}
+	case "T":
+		return strconv.FormatInt(n, 10) + " seconds", nil
+	case "A":
+		return "{}", nil
+	case "I":
+		return fmt.Sprintf("10.%d.%d.%d", n>>16&0xff, n>>8&0xff, n&0xff), nil //nolint:mnd // octets.
+	case "E":
+		var label string
+
+		err := g.q.QueryRow(ctx, "SELECT (enum_range(NULL::"+c.Type+"))[1]::text").Scan(&label)
+		if err != nil {
+			return nil, fmt.Errorf("query labels of enum %s: %w", c.Type, err)
+		}
+
+		return label, nil
+	case "U":
+		switch c.Type {
+		case "uuid":
+			return fmt.Sprintf("%08x-0000-4000-8000-%012x", g.seq.salt, n), nil
+		case "json", "jsonb":
+			return "{}", nil
+		case "bytea":
+			return fmt.Sprintf(`\x%02x`, n&0xff), nil //nolint:mnd // single byte.
+		}
+	}
+
+	return nil, fmt.Errorf("cannot generate a value of type %s for column %s, set it explicitly", c.Type, c.Name)
+}
+
+// insertSQL returns an INSERT statement of set columns into t returning
+// the given expression.
+func insertSQL(t catalog.Table, set map[string]any, returning string) string {
+	var sql strings.Builder
+
+	sql.WriteString("INSERT INTO ")
+	sql.WriteString(t.Identifier().Sanitize())
+	sql.WriteString(" AS t")
+
+	if len(set) == 0 {
+		sql.WriteString(" DEFAULT VALUES")
+	} else {
+		cols := insertColumns(set)
+		params := make([]string, len(cols))
+
+		for i := range cols {
+			params[i] = "$" + strconv.Itoa(i+1)
+		}
+
+		sql.WriteString(" (" + catalog.QuoteIdentifiers(cols) + ") VALUES (" + strings.Join(params, ", ") + ")")
+	}
+
+	sql.WriteString(" RETURNING ")
+	sql.WriteString(returning)
+
+	return sql.String()
+}
+
+// insertArgs returns values of set in the order of insertColumns.
+func insertArgs(set map[string]any) []any {
+	cols := insertColumns(set)
+
+	args := make([]any, len(cols))
+	for i, col := range cols {
+		args[i] = set[col]
+	}
+
+	return args
+}
+
+func insertColumns(set map[string]any) []string {
+	return slices.Sorted(maps.Keys(set))
+}
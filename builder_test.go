@@ -0,0 +1,182 @@
+package pgxephemeraltest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+const builderSchema = `
+CREATE TYPE author_status AS ENUM ('active', 'banned');
+CREATE TABLE authors (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  name TEXT NOT NULL,
+  handle VARCHAR(4) NOT NULL UNIQUE,
+  status author_status NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE books (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  title TEXT NOT NULL,
+  author_id BIGINT NOT NULL REFERENCES authors (id),
+  published_on DATE NOT NULL,
+  isbn UUID NOT NULL
+);`
+
+type Author struct {
+	ID        int64
+	Name      string
+	Handle    string
+	CreatedAt time.Time
+}
+
+type Book struct {
+	ID       int64  `db:"id"`
+	Title    string `db:"title"`
+	AuthorID int64
+	Notes    string `db:"-"`
+}
+
+type bookTitle struct {
+	ID    int64
+	Title string
+}
+
+func (bookTitle) TableName() string { return "books" }
+
+type placement struct {
+	ID   int64
+	Room int64
+	N    int64
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	f, err := pgxephemeraltest.NewPoolFactory(
+		t.Context(),
+		testutil.PoolConfig(t),
+		testutil.NewMigrator(builderSchema, "builder"),
+	)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	t.Run("it fills required columns and honors defaults", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+		// Act
+		authors := pgxephemeraltest.Build[Author](t, tx).InsertN(2)
+
+		// Assert
+		assert.NotZero(t, authors[0].ID)
+		assert.NotEqual(t, authors[0].Handle, authors[1].Handle)
+		assert.LessOrEqual(t, len(authors[0].Handle), 4)
+		assert.NotEmpty(t, authors[0].Name)
+		assert.WithinDuration(t, time.Now(), authors[0].CreatedAt, time.Minute)
+	})
+
+	t.Run("it applies modifications and creates parent rows", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+		books := pgxephemeraltest.Build[Book](t, tx).With(func(b *Book) { b.Title = "Wonderland" })
+
+		// Act
+		book := books.With(func(b *Book) { b.Notes = "skipped" }).Insert()
+
+		// Assert
+		assert.Equal(t, "Wonderland", book.Title)
+		assert.Empty(t, book.Notes)
+
+		var name string
+
+		err := tx.QueryRow(t.Context(), "SELECT name FROM authors WHERE id = $1", book.AuthorID).Scan(&name)
+		require.NoError(t, err)
+		assert.NotEmpty(t, name)
+	})
+
+	t.Run("it references given parent rows", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+		author := pgxephemeraltest.Build[Author](t, tx).Insert()
+
+		// Act
+		book := pgxephemeraltest.Build[Book](t, tx).With(func(b *Book) { b.AuthorID = author.ID }).Insert()
+		title := pgxephemeraltest.Build[bookTitle](t, tx).Insert()
+
+		// Assert
+		assert.Equal(t, author.ID, book.AuthorID)
+		assert.NotEmpty(t, title.Title)
+
+		var count int
+
+		err := tx.QueryRow(t.Context(), "SELECT count(*) FROM authors").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("it generates values unique to the test", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+		author := pgxephemeraltest.Build[Author](t, tx).Insert()
+
+		// Act
+		var other Author
+
+		t.Run("other test", func(t *testing.T) {
+			other = pgxephemeraltest.Build[Author](t, tx).Insert()
+		})
+
+		// Assert
+		assert.NotEqual(t, author.Handle, other.Handle)
+		assert.NotEqual(t, author.Name, other.Name)
+	})
+
+	t.Run("it rejects partly set composite foreign keys", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+		_, err := tx.Exec(t.Context(), `CREATE TABLE shelves (room INT, n INT, PRIMARY KEY (room, n));
+CREATE TABLE placements (
+  id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  room INT NOT NULL,
+  n INT NOT NULL,
+  FOREIGN KEY (room, n) REFERENCES shelves (room, n)
+)`)
+		require.NoError(t, err)
+
+		var (
+			tt    = internaltesting.NewMockTB(gomock.NewController(t))
+			fatal string
+		)
+
+		tt.EXPECT().Helper().AnyTimes()
+		tt.EXPECT().Context().AnyTimes().Return(t.Context())
+		tt.EXPECT().Cleanup(gomock.Any()).AnyTimes().Do(t.Cleanup)
+		tt.EXPECT().Fatal(gomock.Any()).Times(1).Do(func(args ...any) { fatal = fmt.Sprint(args...) })
+
+		// Act
+		pgxephemeraltest.Build[placement](tt, tx).With(func(p *placement) { p.Room = 1 }).Insert()
+
+		// Assert
+		assert.Contains(t, fatal, "foreign key columns room of placements are only partly set")
+	})
+}
@@ -0,0 +1,307 @@
+package pgxephemeraltest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+// captureSchema is the schema holding change capture objects
+// in ephemeral databases.
+const captureSchema = catalog.InternalSchema
+
+// installChangeCaptureSQL creates the capture table and installs audit triggers
+// on all user tables of the current database.
+const installChangeCaptureSQL = `
+CREATE SCHEMA IF NOT EXISTS ` + captureSchema + `;
+
+CREATE TABLE ` + captureSchema + `.changes (
+  id BIGSERIAL PRIMARY KEY,
+  table_schema TEXT NOT NULL,
+  table_name TEXT NOT NULL,
+  op TEXT NOT NULL,
+  old_row JSONB,
+  new_row JSONB
+);
+
+-- Changes made by restricted application roles are captured as well.
+CREATE FUNCTION ` + captureSchema + `.capture_change() RETURNS trigger LANGUAGE plpgsql
+SECURITY DEFINER SET search_path = pg_catalog, pg_temp AS $$
+BEGIN
+  INSERT INTO ` + captureSchema + `.changes (table_schema, table_name, op, old_row, new_row)
+  VALUES (
+    TG_TABLE_SCHEMA,
+    TG_TABLE_NAME,
+    TG_OP,
+    CASE WHEN TG_OP <> 'INSERT' THEN to_jsonb(OLD) END,
+    CASE WHEN TG_OP <> 'DELETE' THEN to_jsonb(NEW) END
+  );
+
+  RETURN NULL;
+END
+$$;
+
+DO $$
+DECLARE
+  t record;
+BEGIN
+  FOR t IN
+    SELECT n.nspname, c.relname
+    FROM pg_class c
+    JOIN pg_namespace n ON n.oid = c.relnamespace
+    WHERE c.relkind IN ('r', 'p')
+      AND NOT c.relispartition
+      AND n.nspname NOT IN ('pg_catalog', 'information_schema', '` + captureSchema + `')
+      AND n.nspname NOT LIKE 'pg\_toast%'
+      AND n.nspname NOT LIKE 'pg\_temp%'
+      AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'e')
+  LOOP
+    EXECUTE format(
+      'CREATE TRIGGER pgxephemeraltest_capture_change AFTER INSERT OR UPDATE OR DELETE ON %I.%I '
+      'FOR EACH ROW EXECUTE FUNCTION ` + captureSchema + `.capture_change()',
+      t.nspname, t.relname
+    );
+  END LOOP;
+END
+$$;
+`
+
+// WithChangeCapture enables row change capture for ephemeral databases created
+// by PoolFactory.
+//
+// Once enabled, generic audit triggers are installed on all user tables
+// of each ephemeral database, so that rows written by a test can be
+// inspected with Changes. Templates are left untouched.
+//
+// Note that TRUNCATE is not captured.
+func WithChangeCapture() FactoryOption {
+	return func(config *factoryOptions) { config.captureChanges = true }
+}
+
+// Row is a table row keyed by column name.
+//
+// Integer values are decoded as int64, other numbers as float64; everything
+// else is decoded as the JSON representation of the Postgres value.
+type Row = map[string]any
+
+// RowUpdate is a row before and after an update.
+type RowUpdate struct {
+	Old Row
+	New Row
+}
+
+// TableChanges holds rows written to a single table in the order
+// they were written.
+type TableChanges struct {
+	Inserted []Row
+	Updated  []RowUpdate
+	Deleted  []Row
+}
+
+// ChangeSet holds row changes keyed by table name. Tables outside
+// of the public schema are keyed by their qualified name, e.g., "audit.events".
+type ChangeSet map[string]*TableChanges
+
+// Table returns changes of the named table. It never returns nil.
+func (c ChangeSet) Table(name string) *TableChanges {
+	if t, ok := c[name]; ok {
+		return t
+	}
+
+	return &TableChanges{Inserted: nil, Updated: nil, Deleted: nil}
+}
+
+// Changes returns rows inserted, updated and deleted by the tb test in
+// ephemeral databases created with WithChangeCapture enabled.
+//
+// Only committed changes are visible.
+func Changes(tb internaltesting.TB) ChangeSet {
+	tb.Helper()
+
+	ret := make(ChangeSet)
+
+	for _, config := range captureConfigs(tb) {
+		err := withConn(tb.Context(), config, func(conn *pgx.Conn) error {
+			return readChanges(tb.Context(), conn, ret)
+		})
+		assertNoError(tb, err, "pgxephemeraltest: failed to read captured changes")
+	}
+
+	return ret
+}
+
+// ResetChanges discards changes captured so far for the tb test, which is
+// handy to exclude rows written while arranging the test.
+func ResetChanges(tb internaltesting.TB) {
+	tb.Helper()
+
+	for _, config := range captureConfigs(tb) {
+		err := withConn(tb.Context(), config, func(conn *pgx.Conn) error {
+			_, err := conn.Exec(tb.Context(), "TRUNCATE "+captureSchema+".changes")
+			return err //nolint:wrapcheck // wrapped by the caller.
+		})
+		assertNoError(tb, err, "pgxephemeraltest: failed to reset captured changes")
+	}
+}
+
+func captureConfigs(tb internaltesting.TB) []*pgx.ConnConfig {
+	tb.Helper()
+
+	s, ok := testStates.Load(tb)
+	if !ok {
+		tb.Fatalf("pgxephemeraltest: no ephemeral pool recorded for test %s", tb.Name())
+		return nil
+	}
+
+	//nolint:forcetypeassert // only *testState is stored.
+	state := s.(*testState)
+
+	state.mu.Lock()
+	captures := append([]*pgx.ConnConfig(nil), state.captures...)
+	state.mu.Unlock()
+
+	if len(captures) == 0 {
+		tb.Fatalf("pgxephemeraltest: change capture is not enabled for test %s, see WithChangeCapture", tb.Name())
+		return nil
+	}
+
+	return captures
+}
+
+// installChangeCapture installs change capture into the db database.
+func (f *PoolFactory) installChangeCapture(ctx context.Context, db string) (*pgx.ConnConfig, error) {
+	config := f.config.ConnConfig.Copy()
+	config.Database = db
+	// Keep the capture queries out of the test query log and user tracers.
+	config.Tracer = nil
+
+	if err := withConn(ctx, config, func(conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, installChangeCaptureSQL)
+		return err //nolint:wrapcheck // wrapped below.
+	}); err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to install change capture: %w", err)
+	}
+
+	return config, nil
+}
+
+func readChanges(ctx context.Context, conn *pgx.Conn, into ChangeSet) error {
+	rows, err := conn.Query(
+		ctx,
+		"SELECT table_schema, table_name, op, old_row::text, new_row::text FROM "+captureSchema+".changes ORDER BY id",
+	)
+	if err != nil {
+		return fmt.Errorf("query changes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			schema, table, op string
+			oldRow, newRow    *string
+		)
+
+		if err := rows.Scan(&schema, &table, &op, &oldRow, &newRow); err != nil {
+			return fmt.Errorf("scan change: %w", err)
+		}
+
+		oldR, err := decodeRow(oldRow)
+		if err != nil {
+			return err
+		}
+
+		newR, err := decodeRow(newRow)
+		if err != nil {
+			return err
+		}
+
+		key := catalog.Table{Schema: schema, Name: table}.Key()
+		if _, ok := into[key]; !ok {
+			into[key] = &TableChanges{Inserted: nil, Updated: nil, Deleted: nil}
+		}
+
+		switch t := into[key]; op {
+		case "INSERT":
+			t.Inserted = append(t.Inserted, newR)
+		case "UPDATE":
+			t.Updated = append(t.Updated, RowUpdate{Old: oldR, New: newR})
+		case "DELETE":
+			t.Deleted = append(t.Deleted, oldR)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate changes: %w", err)
+	}
+
+	return nil
+}
+
+// decodeRow decodes a JSON object produced by to_jsonb into a Row.
+func decodeRow(src *string) (Row, error) {
+	if src == nil {
+		return nil, nil //nolint:nilnil // absent row is not an error.
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(*src)))
+	dec.UseNumber()
+
+	var row Row
+	if err := dec.Decode(&row); err != nil {
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+
+	for k, v := range row {
+		row[k] = normalizeNumbers(v)
+	}
+
+	return row, nil
+}
+
+// normalizeNumbers converts json.Number values found in v into int64 or
+// float64. Numbers that would not encode back to the same text, e.g.,
+// numeric values with many digits or trailing zeros, are kept as json.Number.
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return i
+		}
+
+		if f, err := v.Float64(); err == nil {
+			if b, err := json.Marshal(f); err == nil && string(b) == v.String() {
+				return f
+			}
+		}
+
+		return v
+	case map[string]any:
+		for k, vv := range v {
+			v[k] = normalizeNumbers(vv)
+		}
+	case []any:
+		for i, vv := range v {
+			v[i] = normalizeNumbers(vv)
+		}
+	}
+
+	return v
+}
+
+// withConn runs fn with a new connection established using config.
+func withConn(ctx context.Context, config *pgx.ConnConfig, fn func(*pgx.Conn) error) error {
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return fmt.Errorf("connect to database: %w", err)
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	return fn(conn)
+}
@@ -0,0 +1,113 @@
+package pgxephemeraltest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestDecodeRow(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	src := `{"id": 1, "price": 9.5, "name": "foo", "tags": [1, 2], "meta": {"n": 3}, "deleted_at": null}`
+
+	// Act
+	row, err := decodeRow(&src)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, Row{
+		"id":         int64(1),
+		"price":      9.5,
+		"name":       "foo",
+		"tags":       []any{int64(1), int64(2)},
+		"meta":       map[string]any{"n": int64(3)},
+		"deleted_at": nil,
+	}, row)
+}
+
+func TestDecodeRow_Precision(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	src := `{"big": 12345678901234567890123, "exact": 0.1, "scaled": 1.50, "long": 3.14159265358979323846}`
+
+	// Act
+	row, err := decodeRow(&src)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, Row{
+		"big":    json.Number("12345678901234567890123"),
+		"exact":  0.1,
+		"scaled": json.Number("1.50"),
+		"long":   json.Number("3.14159265358979323846"),
+	}, row)
+}
+
+func TestChanges(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewPoolFactory(
+		t.Context(),
+		testutil.PoolConfig(t),
+		testutil.NewMigrator(
+			testutil.KVSchema+"CREATE SCHEMA audit; CREATE TABLE audit.events (id INT PRIMARY KEY);",
+			"changes-capture",
+		),
+		WithChangeCapture(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	t.Run("it captures rows written by the test", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		pool := f.Pool(t)
+
+		_, err := pool.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ('a', '1'), ('b', '2')")
+		require.NoError(t, err)
+
+		// Act
+		_, err = pool.Exec(t.Context(), "UPDATE kv SET value = '3' WHERE key = 'a'")
+		require.NoError(t, err)
+
+		_, err = pool.Exec(t.Context(), "DELETE FROM kv WHERE key = 'b'")
+		require.NoError(t, err)
+
+		_, err = pool.Exec(t.Context(), "INSERT INTO audit.events (id) VALUES (1)")
+		require.NoError(t, err)
+
+		// Assert
+		changes := Changes(t)
+
+		kv := changes.Table("kv")
+		assert.Equal(t, []Row{{"key": "a", "value": "1"}, {"key": "b", "value": "2"}}, kv.Inserted)
+		assert.Equal(t, []RowUpdate{{Old: Row{"key": "a", "value": "1"}, New: Row{"key": "a", "value": "3"}}}, kv.Updated)
+		assert.Equal(t, []Row{{"key": "b", "value": "2"}}, kv.Deleted)
+
+		assert.Equal(t, []Row{{"id": int64(1)}}, changes.Table("audit.events").Inserted)
+	})
+
+	t.Run("it discards changes on reset", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		pool := f.Pool(t)
+
+		_, err := pool.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ('a', '1')")
+		require.NoError(t, err)
+
+		// Act
+		ResetChanges(t)
+
+		// Assert
+		assert.Empty(t, Changes(t))
+	})
+}
@@ -0,0 +1,50 @@
+package cmdutil
+
+import (
+	"fmt"
+	"io/fs"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/migrator"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+// MigratorArgs are the arguments selecting a migrator.
+type MigratorArgs struct {
+	FromSQL      string
+	FromDump     string
+	NoOwner      bool
+	NoPrivileges bool
+}
+
+// LoadMigrator returns the migrator requested by args, or nil if neither
+// a SQL file nor a dump is given.
+func LoadMigrator(fsys fs.ReadFileFS, args MigratorArgs) (dbmanager.Migrator, error) {
+	switch {
+	case args.FromSQL != "":
+		fileMigrator, err := migrator.FromFile(fsys, args.FromSQL)
+		if err != nil {
+			return nil, fmt.Errorf("load SQL migration file %q: %w", args.FromSQL, err)
+		}
+
+		return fileMigrator, nil
+	case args.FromDump != "":
+		var opts []migrators.DumpOption
+		if args.NoOwner {
+			opts = append(opts, migrators.WithoutOwnership())
+		}
+
+		if args.NoPrivileges {
+			opts = append(opts, migrators.WithoutPrivileges())
+		}
+
+		dumpMigrator, err := migrators.FromDump(fsys, args.FromDump, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("load dump %q: %w", args.FromDump, err)
+		}
+
+		return dumpMigrator, nil
+	default:
+		return nil, nil //nolint:nilnil // no migrator is needed to clone a template.
+	}
+}
@@ -11,14 +11,13 @@ import (
 
 	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/cmdutil"
 	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
-	"go.segfaultmedaddy.com/pgxephemeraltest/internal/migrator"
 )
 
 func New() *cli.Command {
 	//nolint:exhaustruct
 	return &cli.Command{
 		Name:  "create",
-		Usage: "Create an ephemeral database from a template or SQL file",
+		Usage: "Create an ephemeral database from a template, SQL file or pg_dump output",
 		MutuallyExclusiveFlags: []cli.MutuallyExclusiveFlags{{
 			//nolint:exhaustruct
 			Required: true,
@@ -32,6 +31,10 @@ func New() *cli.Command {
 						Name:  "from-sql",
 						Usage: "Path to a SQL file to use as migration",
 					}, //nolint:exhaustruct
+					&cli.StringFlag{
+						Name:  "from-dump",
+						Usage: "Path to a plain-format pg_dump output to use as migration",
+					}, //nolint:exhaustruct
 				},
 			},
 		}},
@@ -39,6 +42,10 @@ func New() *cli.Command {
 			cmdutil.ConnURLFlag(),
 			//nolint:exhaustruct
 			&cli.StringFlag{Required: true, Name: "db-name", Usage: "Name for the new database"},
+			//nolint:exhaustruct
+			&cli.BoolFlag{Name: "no-owner", Usage: "Skip ALTER ... OWNER TO statements of the dump"},
+			//nolint:exhaustruct
+			&cli.BoolFlag{Name: "no-privileges", Usage: "Skip GRANT and REVOKE statements of the dump"},
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			cwd, err := os.Getwd()
@@ -54,6 +61,9 @@ func New() *cli.Command {
 				DatabaseName: cmd.String("db-name"),
 				FromTemplate: cmd.String("from-template"),
 				FromSQL:      cmd.String("from-sql"),
+				FromDump:     cmd.String("from-dump"),
+				NoOwner:      cmd.Bool("no-owner"),
+				NoPrivileges: cmd.Bool("no-privileges"),
 			}))
 		},
 	}
@@ -64,6 +74,9 @@ type args struct {
 	DatabaseName string
 	FromTemplate string
 	FromSQL      string
+	FromDump     string
+	NoOwner      bool
+	NoPrivileges bool
 }
 
 func create(ctx context.Context, fsys fs.ReadFileFS, args args) (any, error) {
@@ -76,18 +89,24 @@ func create(ctx context.Context, fsys fs.ReadFileFS, args args) (any, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create database manager: %w", err)
 	}
+	defer m.Close()
 
 	template := args.FromTemplate
 	ret := make([]dbmanager.DBInfo, 0, 2)
 
-	if args.FromSQL != "" {
-		fileMigrator, err := migrator.FromFile(fsys, args.FromSQL)
-		if err != nil {
-			return nil, fmt.Errorf("load SQL migration file %q: %w", args.FromSQL, err)
-		}
+	mig, err := cmdutil.LoadMigrator(fsys, cmdutil.MigratorArgs{
+		FromSQL:      args.FromSQL,
+		FromDump:     args.FromDump,
+		NoOwner:      args.NoOwner,
+		NoPrivileges: args.NoPrivileges,
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // already wrapped.
+	}
 
-		template = dbmanager.TemplateName(config.ConnConfig, fileMigrator)
-		if err := m.Init(ctx, fileMigrator, template); err != nil {
+	if mig != nil {
+		template = dbmanager.TemplateName(config.ConnConfig, mig)
+		if err := m.Init(ctx, mig, template); err != nil {
 			return nil, fmt.Errorf("initialize template %q: %w", template, err)
 		}
 
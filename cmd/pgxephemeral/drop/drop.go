@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"os"
 	"slices"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -65,6 +66,7 @@ func drop(ctx context.Context, args args) (any, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create database manager: %w", err)
 	}
+	defer m.Close()
 
 	knownDBs, err := m.ListDBs(ctx)
 	if err != nil {
@@ -94,6 +96,11 @@ func drop(ctx context.Context, args args) (any, error) {
 		return nil, errors.New("no matching databases to drop")
 	}
 
+	// Report sessions terminated by the drop, so leaks can be tracked down.
+	ctx = dbmanager.ContextWithLogf(ctx, func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	})
+
 	names := slices.Collect(maps.Keys(toDrop))
 	if err := m.DropDBs(ctx, names); err != nil {
 		if args.All {
@@ -0,0 +1,86 @@
+package droproles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/urfave/cli/v3"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/cmdutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+func New() *cli.Command {
+	//nolint:exhaustruct
+	return &cli.Command{
+		Name:  "drop-roles",
+		Usage: "Drop roles created by factories, after the databases referring to them are dropped",
+		Flags: []cli.Flag{cmdutil.ConnURLFlag()},
+		MutuallyExclusiveFlags: []cli.MutuallyExclusiveFlags{{
+			//nolint:exhaustruct
+			Required: true,
+			Flags: [][]cli.Flag{ //nolint:exhaustruct
+				{
+					&cli.StringSliceFlag{
+						Name:  "role-name",
+						Usage: "Name of the role to drop (repeatable)",
+					}, //nolint:exhaustruct
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Drop all managed roles",
+					}, //nolint:exhaustruct
+				},
+			},
+		}},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return cmdutil.Write(dropRoles(ctx, args{
+				ConnURL:   cmd.String("conn-url"),
+				RoleNames: cmd.StringSlice("role-name"),
+				All:       cmd.Bool("all"),
+			}))
+		},
+	}
+}
+
+type args struct {
+	ConnURL   string
+	RoleNames []string
+	All       bool
+}
+
+func dropRoles(ctx context.Context, args args) (any, error) {
+	config, err := pgxpool.ParseConfig(args.ConnURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse connection URL: %w", err)
+	}
+
+	m, err := dbmanager.New(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("create database manager: %w", err)
+	}
+	defer m.Close()
+
+	roles, err := m.ListRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list managed roles: %w", err)
+	}
+
+	if !args.All {
+		roles = slices.DeleteFunc(roles, func(role string) bool {
+			return !slices.Contains(args.RoleNames, role)
+		})
+	}
+
+	if len(roles) == 0 {
+		return nil, errors.New("no matching roles to drop")
+	}
+
+	if err := m.DropRoles(ctx, roles); err != nil {
+		return nil, fmt.Errorf("drop selected roles: %w", err)
+	}
+
+	return roles, nil
+}
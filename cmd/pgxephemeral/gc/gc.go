@@ -0,0 +1,72 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/urfave/cli/v3"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/cmdutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+func New() *cli.Command {
+	//nolint:exhaustruct
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "Drop stale templates, keeping the most recently used ones of each migrator lineage",
+		Flags: []cli.Flag{
+			cmdutil.ConnURLFlag(),
+			//nolint:exhaustruct
+			&cli.IntFlag{
+				Name:  "keep-latest",
+				Usage: "Number of the most recently used templates to keep for each lineage",
+			},
+			//nolint:exhaustruct
+			&cli.DurationFlag{
+				Name:  "ttl",
+				Usage: "Keep templates used within the duration",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return cmdutil.Write(gc(ctx, args{
+				ConnURL:    cmd.String("conn-url"),
+				KeepLatest: cmd.Int("keep-latest"),
+				TTL:        cmd.Duration("ttl"),
+			}))
+		},
+	}
+}
+
+type args struct {
+	ConnURL    string
+	KeepLatest int
+	TTL        time.Duration
+}
+
+func gc(ctx context.Context, args args) (any, error) {
+	config, err := pgxpool.ParseConfig(args.ConnURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse connection URL: %w", err)
+	}
+
+	m, err := dbmanager.New(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("create database manager: %w", err)
+	}
+	defer m.Close()
+
+	dropped, err := m.CollectTemplates(ctx, dbmanager.GCPolicy{KeepLatest: args.KeepLatest, TTL: args.TTL})
+	if err != nil {
+		return nil, fmt.Errorf("collect stale templates: %w", err)
+	}
+
+	ret := make([]dbmanager.DBInfo, 0, len(dropped))
+	for _, name := range dropped {
+		ret = append(ret, dbmanager.DBInfo{Name: name, IsTemplate: true})
+	}
+
+	return ret, nil
+}
@@ -43,6 +43,7 @@ func list(ctx context.Context, args args) (any, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create database manager: %w", err)
 	}
+	defer m.Close()
 
 	dbs, err := m.ListDBs(ctx)
 	if err != nil {
@@ -9,7 +9,10 @@ import (
 
 	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/create"
 	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/drop"
+	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/droproles"
+	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/gc"
 	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/list"
+	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/schemacheck"
 )
 
 func main() {
@@ -21,9 +24,16 @@ func main() {
 func run(ctx context.Context) error {
 	//nolint:exhaustruct
 	app := cli.Command{
-		Name:     "pgxephemeral",
-		Usage:    "Manage ephemeral PostgreSQL databases for testing",
-		Commands: []*cli.Command{create.New(), drop.New(), list.New()},
+		Name:  "pgxephemeral",
+		Usage: "Manage ephemeral PostgreSQL databases for testing",
+		Commands: []*cli.Command{
+			create.New(),
+			drop.New(),
+			droproles.New(),
+			gc.New(),
+			list.New(),
+			schemacheck.New(),
+		},
 	}
 
 	if err := app.Run(ctx, os.Args); err != nil {
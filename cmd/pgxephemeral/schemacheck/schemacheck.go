@@ -0,0 +1,160 @@
+package schemacheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/urfave/cli/v3"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/cmd/pgxephemeral/cmdutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+var errSchemaDrift = errors.New("schema does not match the reference schema")
+
+func New() *cli.Command {
+	//nolint:exhaustruct
+	return &cli.Command{
+		Name:  "schema-check",
+		Usage: "Compare the schema of migrations with a reference schema file",
+		MutuallyExclusiveFlags: []cli.MutuallyExclusiveFlags{{
+			//nolint:exhaustruct
+			Required: true,
+			Flags: [][]cli.Flag{ //nolint:exhaustruct
+				{
+					&cli.StringFlag{
+						Name:  "from-template",
+						Usage: "Name of an existing template to check",
+					}, //nolint:exhaustruct
+					&cli.StringFlag{
+						Name:  "from-sql",
+						Usage: "Path to a SQL file to use as migration",
+					}, //nolint:exhaustruct
+					&cli.StringFlag{
+						Name:  "from-dump",
+						Usage: "Path to a plain-format pg_dump output to use as migration",
+					}, //nolint:exhaustruct
+				},
+			},
+		}},
+		Flags: []cli.Flag{
+			cmdutil.ConnURLFlag(),
+			//nolint:exhaustruct
+			&cli.StringFlag{
+				Required: true,
+				Name:     "schema",
+				Usage:    "Path to the reference schema file, plain SQL or pg_dump output",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return cmdutil.Write(nil, fmt.Errorf("get current working directory: %w", err))
+			}
+
+			//nolint:forcetypeassert // os.DirFS for real dirs implements fs.ReadFileFS.
+			fsys := os.DirFS(cwd).(fs.ReadFileFS)
+
+			res, err := check(ctx, fsys, args{
+				ConnURL:      cmd.String("conn-url"),
+				Schema:       cmd.String("schema"),
+				FromTemplate: cmd.String("from-template"),
+				FromSQL:      cmd.String("from-sql"),
+				FromDump:     cmd.String("from-dump"),
+			})
+			if err := cmdutil.Write(res, err); err != nil {
+				return err
+			}
+
+			if !res.Matches {
+				return cli.Exit("", 1)
+			}
+
+			return nil
+		},
+	}
+}
+
+type args struct {
+	ConnURL      string
+	Schema       string
+	FromTemplate string
+	FromSQL      string
+	FromDump     string
+}
+
+type result struct {
+	Template string                        `json:"template"`
+	Matches  bool                          `json:"matches"`
+	Missing  []catalog.SchemaObject        `json:"missing"`
+	Extra    []catalog.SchemaObject        `json:"extra"`
+	Changed  []catalog.ChangedSchemaObject `json:"changed"`
+}
+
+func check(ctx context.Context, fsys fs.ReadFileFS, args args) (*result, error) {
+	config, err := pgxpool.ParseConfig(args.ConnURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse connection URL: %w", err)
+	}
+
+	m, err := dbmanager.New(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("create database manager: %w", err)
+	}
+	defer m.Close()
+
+	reference, err := migrators.FromDump(
+		fsys,
+		args.Schema,
+		migrators.WithoutOwnership(),
+		migrators.WithoutPrivileges(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load reference schema %q: %w", args.Schema, err)
+	}
+
+	template := args.FromTemplate
+
+	mig, err := cmdutil.LoadMigrator(fsys, cmdutil.MigratorArgs{
+		FromSQL:      args.FromSQL,
+		FromDump:     args.FromDump,
+		NoOwner:      true,
+		NoPrivileges: true,
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // already wrapped.
+	}
+
+	if mig != nil {
+		template = dbmanager.TemplateName(config.ConnConfig, mig)
+		if err := m.Init(ctx, mig, template); err != nil {
+			return nil, fmt.Errorf("initialize template %q: %w", template, err)
+		}
+	}
+
+	want, err := m.DescribeMigration(ctx, reference, "")
+	if err != nil {
+		return nil, fmt.Errorf("describe reference schema: %w", err)
+	}
+
+	got, err := m.DescribeMigration(ctx, nil, template)
+	if err != nil {
+		return nil, fmt.Errorf("describe schema of template %q: %w", template, err)
+	}
+
+	diff := catalog.DiffSchemas(want, got)
+
+	return &result{
+		Template: template,
+		Matches:  diff.Empty(),
+		Missing:  diff.Removed,
+		Extra:    diff.Added,
+		Changed:  diff.Changed,
+	}, nil
+}
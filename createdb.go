@@ -0,0 +1,30 @@
+package pgxephemeraltest
+
+import "go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+
+// Database creation strategies, see CreateDatabaseOptions.Strategy.
+const (
+	StrategyWALLog   = dbmanager.StrategyWALLog
+	StrategyFileCopy = dbmanager.StrategyFileCopy
+)
+
+// ErrInvalidCreateOptions is returned when CreateDatabaseOptions are unknown
+// or unsupported by the server, e.g., ICU locales on PostgreSQL 14.
+var ErrInvalidCreateOptions = dbmanager.ErrInvalidCreateOptions
+
+// CreateDatabaseOptions are options of CREATE DATABASE used for templates
+// and ephemeral databases.
+type CreateDatabaseOptions = dbmanager.CreateOptions
+
+// WithCreateDatabaseOptions makes PoolFactory create templates and ephemeral
+// databases with the options, e.g., with the FILE_COPY strategy, which is
+// faster for large templates on PostgreSQL 15+, or in a tmpfs-backed
+// tablespace.
+//
+// The options, except for the strategy, are part of the template identity,
+// so factories with different options do not share templates. Options
+// unsupported by the server fail factory creation, except for the strategy,
+// which is ignored by servers older than PostgreSQL 15.
+func WithCreateDatabaseOptions(options CreateDatabaseOptions) FactoryOption {
+	return func(config *factoryOptions) { config.createOptions = options }
+}
@@ -0,0 +1,79 @@
+package pgxephemeraltest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestWithCreateDatabaseOptions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it creates databases with the options", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		migrator := testutil.NewMigrator("CREATE TABLE kv (k TEXT PRIMARY KEY, v TEXT);", "create-options")
+
+		plain, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, migrator)
+		require.NoError(t, err)
+		t.Cleanup(plain.Close)
+
+		//nolint:exhaustruct // only some options are set.
+		options := pgxephemeraltest.CreateDatabaseOptions{
+			Strategy: pgxephemeraltest.StrategyFileCopy,
+			Encoding: "SQL_ASCII",
+			Locale:   "C",
+		}
+
+		// Act
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithCreateDatabaseOptions(options),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Assert
+		assert.NotEqual(t, plain.Template(), f.Template())
+
+		var encoding, collate string
+
+		err = f.Pool(t).QueryRow(t.Context(), `
+SELECT pg_encoding_to_char(encoding), datcollate
+FROM pg_database
+WHERE datname = current_database()`).Scan(&encoding, &collate)
+		require.NoError(t, err)
+		assert.Equal(t, "SQL_ASCII", encoding)
+		assert.Equal(t, "C", collate)
+	})
+
+	t.Run("it rejects unknown strategies", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		migrator := testutil.NewMigrator("CREATE TABLE kv (k TEXT PRIMARY KEY, v TEXT);", "create-options")
+
+		//nolint:exhaustruct // only some options are set.
+		options := pgxephemeraltest.CreateDatabaseOptions{Strategy: "MAGIC", Locale: "C"}
+
+		// Act
+		_, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithCreateDatabaseOptions(options),
+		)
+
+		// Assert
+		require.ErrorIs(t, err, pgxephemeraltest.ErrInvalidCreateOptions)
+	})
+}
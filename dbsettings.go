@@ -0,0 +1,50 @@
+package pgxephemeraltest
+
+import "maps"
+
+// WithDatabaseSettings sets configuration parameters on each ephemeral
+// database with ALTER DATABASE ... SET, e.g., "statement_timeout" or
+// "TimeZone", so they apply to every session connecting to the database
+// without access to the server configuration.
+//
+// Settings of repeated calls are merged, later values take precedence.
+// Custom parameters must be qualified, e.g., "app.tenant_id".
+func WithDatabaseSettings(settings map[string]string) FactoryOption {
+	return func(options *factoryOptions) {
+		if options.databaseSettings == nil {
+			options.databaseSettings = make(map[string]string, len(settings))
+		}
+
+		maps.Copy(options.databaseSettings, settings)
+	}
+}
+
+// FastTestSettings returns database settings trading durability for speed
+// and making results independent of the server configuration:
+//
+//   - synchronous_commit is off, so commits do not wait for WAL flushes;
+//   - jit is off, as compiling short test queries costs more than it saves;
+//   - TimeZone, DateStyle and IntervalStyle are fixed;
+//   - lock_timeout, statement_timeout and
+//     idle_in_transaction_session_timeout fail stuck tests instead of
+//     hanging them.
+//
+// The returned map may be modified before passing it to
+// WithDatabaseSettings.
+func FastTestSettings() map[string]string {
+	return map[string]string{
+		"synchronous_commit":                  "off",
+		"jit":                                 "off",
+		"TimeZone":                            "UTC",
+		"DateStyle":                           "ISO, MDY",
+		"IntervalStyle":                       "postgres",
+		"lock_timeout":                        "10s",
+		"statement_timeout":                   "60s",
+		"idle_in_transaction_session_timeout": "60s",
+	}
+}
+
+// WithFastTestSettings sets FastTestSettings on each ephemeral database.
+func WithFastTestSettings() FactoryOption {
+	return WithDatabaseSettings(FastTestSettings())
+}
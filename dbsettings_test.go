@@ -0,0 +1,55 @@
+package pgxephemeraltest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestWithDatabaseSettings(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it applies settings to ephemeral databases", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		migrator := testutil.NewMigrator("CREATE TABLE kv (k TEXT PRIMARY KEY, v TEXT);", "db-settings")
+
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithFastTestSettings(),
+			pgxephemeraltest.WithDatabaseSettings(map[string]string{
+				"statement_timeout": "5s",
+				"app.tenant_id":     "it's-42",
+			}),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Act
+		pool := f.Pool(t)
+
+		// Assert
+		for name, want := range map[string]string{
+			"synchronous_commit": "off",
+			"TimeZone":           "UTC",
+			"DateStyle":          "ISO, MDY",
+			"statement_timeout":  "5s",
+			"app.tenant_id":      "it's-42",
+		} {
+			var got string
+
+			err := pool.QueryRow(t.Context(), "SELECT current_setting($1)", name).Scan(&got)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, name)
+		}
+	})
+
+}
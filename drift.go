@@ -0,0 +1,61 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+// DiffSchema compares the template schema with the reference schema
+// produced by running the reference migrator on an empty database.
+//
+// Objects only present in the reference schema are reported as removed,
+// objects only present in the template are reported as added.
+func (f *PoolFactory) DiffSchema(ctx context.Context, reference Migrator) (*SchemaDiff, error) {
+	want, err := f.m.DescribeMigration(ctx, reference, "")
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to describe reference schema: %w", err)
+	}
+
+	got, err := f.m.DescribeMigration(ctx, nil, f.template)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to describe template schema: %w", err)
+	}
+
+	return catalog.DiffSchemas(want, got), nil
+}
+
+// AssertSchemaMatches asserts that the template schema matches the reference
+// schema file, e.g., a committed schema.sql kept alongside migrations.
+//
+// The file is either plain SQL or plain-format pg_dump output. It is loaded
+// into a throwaway database, skipping ownership and privilege statements,
+// and the catalogs of both databases are compared. Missing, extra and
+// different objects are reported on failure.
+func AssertSchemaMatches(tb internaltesting.TB, f *PoolFactory, path string) {
+	tb.Helper()
+
+	reference, err := migrators.FromDump(
+		os.DirFS(filepath.Dir(path)),
+		filepath.Base(path),
+		migrators.WithoutOwnership(),
+		migrators.WithoutPrivileges(),
+	)
+	assertNoError(tb, err)
+
+	diff, err := f.DiffSchema(tb.Context(), reference)
+	assertNoError(tb, err)
+
+	if !diff.Empty() {
+		tb.Errorf(
+			"pgxephemeraltest: schema does not match %s (- missing, + extra, ~ different):\n%s",
+			path,
+			diff,
+		)
+	}
+}
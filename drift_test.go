@@ -0,0 +1,62 @@
+package pgxephemeraltest_test
+
+import (
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+func TestAssertSchemaMatches(t *testing.T) {
+	t.Parallel()
+
+	config := testutil.PoolConfig(t)
+
+	t.Run("it passes when migrations match the schema file", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, testutil.NewKVMigrator())
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Act & Assert
+		pgxephemeraltest.AssertSchemaMatches(t, f, "testdata/kv_schema.sql")
+	})
+
+	t.Run("it reports objects diverging from the schema file", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		migrator := testutil.NewMigrator(
+			"CREATE TABLE kv (key TEXT PRIMARY KEY, value TEXT, created_at TIMESTAMPTZ)",
+			"drift-"+strconv.FormatInt(rand.Int64(), 10), // #nosec G404
+		)
+
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, migrator)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		reference, err := migrators.FromDump(
+			os.DirFS("testdata"),
+			"kv_schema.sql",
+			migrators.WithoutOwnership(),
+		)
+		require.NoError(t, err)
+
+		// Act
+		diff, err := f.DiffSchema(t.Context(), reference)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, "+ column public.kv.created_at: timestamp with time zone\n"+
+			"~ column public.kv.value:\n    - text NOT NULL\n    + text", diff.String())
+	})
+}
@@ -51,6 +51,7 @@ func TestMain(m *testing.M) {
 
 	code := m.Run()
 
+	factory.Close()
 	cancel()
 	os.Exit(code)
 }
@@ -0,0 +1,106 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+var (
+	_ dbmanager.Migrator = (*extensionsMigrator)(nil)
+	_ dbmanager.Lineager = (*extensionsMigrator)(nil)
+)
+
+// ErrExtensionUnavailable is returned when extensions required by
+// WithExtensions are not available on the server.
+var ErrExtensionUnavailable = dbmanager.ErrExtensionUnavailable
+
+// WithExtensions makes PoolFactory install the named extensions, e.g.,
+// "pgcrypto" or "citext", into the template before running the migrator.
+//
+// Extensions are checked against pg_available_extensions on factory
+// creation, failing with an error listing the missing ones. Extension names
+// and versions are part of the template identity, so upgrading an extension
+// on the server builds a new template.
+func WithExtensions(names ...string) FactoryOption {
+	return func(config *factoryOptions) { config.extensions = append(config.extensions, names...) }
+}
+
+// withExtensions returns the migrator preceded by installation of
+// the extensions set by options, if any.
+func (p *factoryOptions) withExtensions(
+	ctx context.Context,
+	m *dbmanager.DBManager,
+	migrator Migrator,
+) (Migrator, error) {
+	ext, err := p.extensionsMigrator(ctx, m)
+	if err != nil || ext == nil {
+		return migrator, err
+	}
+
+	return migrators.Chain(ext, migrator), nil
+}
+
+// extensionsMigrator returns the migrator installing the extensions set
+// by options, or nil if there are none.
+func (p *factoryOptions) extensionsMigrator(ctx context.Context, m *dbmanager.DBManager) (Migrator, error) {
+	if len(p.extensions) == 0 {
+		return nil, nil //nolint:nilnil // no extensions to install.
+	}
+
+	versions, err := m.ExtensionVersions(ctx, p.extensions)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // already wrapped.
+	}
+
+	return newExtensionsMigrator(p.extensions, versions), nil
+}
+
+// extensionsMigrator installs extensions.
+//
+// Its lineage is built from the extension names, so that chaining it keeps
+// the lineage of the migrator it precedes, see migrators.ChainMigrator.
+type extensionsMigrator struct {
+	names []string
+	hash  string
+}
+
+func newExtensionsMigrator(names []string, versions map[string]string) extensionsMigrator {
+	h := sha256.New()
+	h.Write([]byte("extensions"))
+
+	for _, name := range names {
+		field := name + "@" + versions[name]
+		h.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+
+	return extensionsMigrator{names: names, hash: hex.EncodeToString(h.Sum(nil))}
+}
+
+func (m extensionsMigrator) Hash() string { return m.hash }
+
+func (m extensionsMigrator) Lineage() string {
+	return "extensions(" + strings.Join(slices.Sorted(slices.Values(m.names)), ", ") + ")"
+}
+
+func (m extensionsMigrator) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	for _, name := range m.names {
+		if _, err := conn.Exec(
+			ctx,
+			"CREATE EXTENSION IF NOT EXISTS "+pgx.Identifier{name}.Sanitize()+" CASCADE",
+		); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to create extension %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package pgxephemeraltest_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+func TestWithExtensions(t *testing.T) {
+	t.Parallel()
+
+	config := testutil.PoolConfig(t)
+
+	t.Run("it installs extensions before running the migrator", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		migrator := testutil.NewMigrator("CREATE TABLE secrets (id INT PRIMARY KEY, digest BYTEA)", "secrets")
+
+		// Act
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithExtensions("pgcrypto"),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		plain, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, migrator)
+		require.NoError(t, err)
+		t.Cleanup(plain.Close)
+
+		// Assert
+		assert.NotEqual(t, plain.Template(), f.Template())
+
+		_, err = f.Pool(t).Exec(
+			t.Context(),
+			"INSERT INTO secrets (id, digest) VALUES (1, digest('s', 'sha256'))",
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("it installs extensions into the version 0 upgrade template", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		steps := []pgxephemeraltest.Migrator{
+			testutil.NewMigrator(
+				"CREATE TABLE secrets (id INT PRIMARY KEY, digest BYTEA)",
+				"upgrade-secrets",
+			),
+		}
+
+		// Act
+		f, err := pgxephemeraltest.NewUpgradeFactory(
+			t.Context(),
+			config,
+			steps,
+			pgxephemeraltest.WithExtensions("pgcrypto"),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		plain, err := pgxephemeraltest.NewUpgradeFactory(t.Context(), config, steps)
+		require.NoError(t, err)
+		t.Cleanup(plain.Close)
+
+		// Assert
+		assert.NotEqual(t, plain.Version(0).Template(), f.Version(0).Template())
+
+		_, err = f.Version(0).Pool(t).Exec(t.Context(), "SELECT digest('s', 'sha256')")
+		require.NoError(t, err)
+	})
+
+	t.Run("it keeps the lineage of the migrator", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		lineage := "extensions-" + strconv.FormatInt(rand.Int64(), 10) // #nosec G404
+		migrator := migrators.Func(lineage, func(ctx context.Context, conn *pgx.Conn) error {
+			_, err := conn.Exec(ctx, "CREATE TABLE secrets (id INT PRIMARY KEY, digest BYTEA)")
+			return err
+		}).WithLineage(lineage)
+
+		// Act
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithExtensions("pgcrypto", "citext"),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Assert
+		var actual string
+
+		err = f.Pool(t).QueryRow(
+			t.Context(),
+			`SELECT shobj_description(oid, 'pg_database')::jsonb->>'lineage'
+FROM pg_database
+WHERE datname = $1`,
+			f.Template(),
+		).Scan(&actual)
+		require.NoError(t, err)
+		assert.Equal(t, config.ConnConfig.User+"/chain(extensions(citext, pgcrypto), "+lineage+")", actual)
+	})
+
+	t.Run("it reports missing extensions", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		_, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			testutil.NewNoopMigrator(),
+			pgxephemeraltest.WithExtensions("pgcrypto", "no_such_extension"),
+		)
+
+		// Assert
+		require.ErrorIs(t, err, pgxephemeraltest.ErrExtensionUnavailable)
+		assert.Contains(t, err.Error(), "no_such_extension")
+		assert.NotContains(t, err.Error(), "pgcrypto")
+	})
+}
@@ -0,0 +1,89 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+// StaleTemplatePolicy defines what PoolFactory does when the cached template
+// does not match the migrator output.
+type StaleTemplatePolicy int
+
+const (
+	// StaleTemplateIgnore skips the check, templates are trusted as long as
+	// the migrator hash is unchanged. It is the default.
+	StaleTemplateIgnore StaleTemplatePolicy = iota
+
+	// StaleTemplateWarn logs a warning with slog.Default.
+	StaleTemplateWarn
+
+	// StaleTemplateRebuild drops the stale template and builds it again.
+	StaleTemplateRebuild
+)
+
+// WithStaleTemplateCheck makes PoolFactory verify that the cached template
+// matches what the migrator produces, which catches migrators changed
+// without changing their Hash.
+//
+// The check runs the migrator on a scratch database on factory creation
+// and compares its schema fingerprint with the one of the template.
+func WithStaleTemplateCheck(policy StaleTemplatePolicy) FactoryOption {
+	return func(config *factoryOptions) { config.staleTemplatePolicy = policy }
+}
+
+// SchemaFingerprint returns the schema fingerprint of the template.
+//
+// The fingerprint is a hash of a normalized description of user objects,
+// such as tables, columns, indexes, constraints, functions and types, taken
+// from the system catalog after running the migrator. Unlike Migrator.Hash,
+// it changes only when the resulting schema changes.
+func (f *PoolFactory) SchemaFingerprint() string { return f.fingerprint }
+
+// checkTemplate applies the stale template policy to the template built
+// from the migrator on top of the base template, or from scratch if base
+// is empty.
+func checkTemplate(
+	ctx context.Context,
+	m *dbmanager.DBManager,
+	migrator Migrator,
+	base, template string,
+	policy StaleTemplatePolicy,
+) error {
+	if policy == StaleTemplateIgnore {
+		return nil
+	}
+
+	cached, err := m.Fingerprint(ctx, template)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to fingerprint template: %w", err)
+	}
+
+	actual, err := m.MigrationFingerprint(ctx, migrator, base)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to fingerprint migration: %w", err)
+	}
+
+	if cached == actual {
+		return nil
+	}
+
+	if policy == StaleTemplateWarn {
+		slog.WarnContext(ctx, "pgxephemeraltest: template does not match migrator output, "+
+			"the migrator has likely changed without changing its hash",
+			slog.String("template", template),
+			slog.String("template_fingerprint", cached),
+			slog.String("migration_fingerprint", actual),
+		)
+
+		return nil
+	}
+
+	if err := m.RebuildFrom(ctx, migrator, base, template, cached); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to rebuild stale template: %w", err)
+	}
+
+	return nil
+}
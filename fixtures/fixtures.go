@@ -0,0 +1,367 @@
+// Package fixtures loads declarative test data into Postgres.
+//
+// Fixtures are described as rows grouped by table in YAML, JSON or CSV files.
+// YAML and JSON files map table names either to a list of rows or to a mapping
+// of labels to rows:
+//
+//	authors:
+//	  alice:
+//	    name: Alice
+//	books:
+//	  - title: Wonderland
+//	    author_id: "@alice"
+//
+// CSV files hold rows of a single table named after the file, e.g. authors.csv,
+// with the header row listing columns. Empty CSV fields are loaded as NULL.
+// The special _label column assigns a label to a row in CSV files, as well as
+// in list entries of YAML and JSON files.
+//
+// A string value of the form "@label" refers to the primary key of the labeled
+// row, and "@label.column" refers to an arbitrary column of it. Use "@@" to
+// escape a literal leading "@". In YAML, "@" can't start a plain scalar, so
+// references must be quoted, i.e., author_id: "@alice" rather than
+// author_id: @alice, which is rejected with ErrUnquotedReference.
+//
+// Tables are loaded in the order of their foreign key dependencies.
+package fixtures
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LabelColumn is the column name assigning a label to a row.
+const LabelColumn = "_label"
+
+// ErrUnquotedReference is returned when a YAML file has a reference that is
+// not quoted, which is invalid YAML.
+var ErrUnquotedReference = errors.New("references must be quoted in YAML")
+
+// unquotedReferenceRe matches YAML values starting with "@", either mapping
+// values or list entries.
+var unquotedReferenceRe = regexp.MustCompile(`(?m)^(?:[^#'"\n]*:|\s*-)\s+(@\S*)`)
+
+// Fixture is a single row of a table.
+type Fixture struct {
+	// Label identifies the row for references, may be empty.
+	Label string
+
+	// Values are column values keyed by column name.
+	Values map[string]any
+}
+
+// Set is a set of fixtures grouped by table.
+//
+// Set implements pgxephemeraltest.Migrator, so fixtures can be baked
+// into a template.
+type Set struct {
+	tables map[string][]Fixture
+	// order is the order tables appeared in the source files.
+	order []string
+	// hash is the hash of the fixture sources.
+	hash []byte
+}
+
+// Load loads fixtures from files of fsys, dispatching on file extension:
+// .yaml, .yml, .json or .csv.
+func Load(fsys fs.FS, paths ...string) (*Set, error) {
+	s := New()
+
+	for _, p := range paths {
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: read %q: %w", p, err)
+		}
+
+		if err := s.Parse(p, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// New returns an empty Set.
+func New() *Set {
+	return &Set{tables: make(map[string][]Fixture), order: nil, hash: nil}
+}
+
+// Parse adds fixtures parsed from data to the set, where name is the name
+// of the source file used to detect the format.
+func (s *Set) Parse(name string, data []byte) error {
+	var err error
+
+	switch ext := strings.ToLower(path.Ext(name)); ext {
+	case ".yaml", ".yml", ".json":
+		// JSON is a subset of YAML.
+		err = s.parseYAML(data)
+	case ".csv":
+		err = s.parseCSV(strings.TrimSuffix(path.Base(name), path.Ext(name)), data)
+	default:
+		err = fmt.Errorf("unsupported format %q", ext)
+	}
+
+	if err != nil {
+		return fmt.Errorf("fixtures: parse %q: %w", name, err)
+	}
+
+	h := sha256.New()
+	h.Write(s.hash)
+	h.Write([]byte(strconv.Itoa(len(name))))
+	h.Write([]byte(name))
+	h.Write(data)
+	s.hash = h.Sum(nil)
+
+	return nil
+}
+
+// Add adds fixtures for the table. The fixtures are folded into the hash
+// of the set, see Hash.
+func (s *Set) Add(table string, fixtures ...Fixture) {
+	s.add(table, fixtures...)
+
+	h := sha256.New()
+	h.Write(s.hash)
+	writeHashField(h, table)
+
+	for _, f := range fixtures {
+		writeHashField(h, f.Label)
+		writeHashField(h, strconv.Itoa(len(f.Values)))
+
+		for _, col := range slices.Sorted(maps.Keys(f.Values)) {
+			writeHashField(h, col)
+			writeHashField(h, hashValue(f.Values[col]))
+		}
+	}
+
+	s.hash = h.Sum(nil)
+}
+
+// add adds fixtures for the table without changing the hash, which is used
+// by parsers hashing the sources instead.
+func (s *Set) add(table string, fixtures ...Fixture) {
+	if _, ok := s.tables[table]; !ok {
+		s.order = append(s.order, table)
+	}
+
+	s.tables[table] = append(s.tables[table], fixtures...)
+}
+
+// Tables returns names of the tables in the order they were added.
+func (s *Set) Tables() []string { return append([]string(nil), s.order...) }
+
+// Fixtures returns fixtures of the table.
+func (s *Set) Fixtures(table string) []Fixture { return s.tables[table] }
+
+// Hash returns a hash of the sources the set was parsed from and
+// the fixtures added to it.
+func (s *Set) Hash() string { return hex.EncodeToString(s.hash) }
+
+// writeHashField writes a length-prefixed field, so that adjacent fields
+// can't collide by concatenation.
+func writeHashField(h hash.Hash, field string) {
+	h.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+}
+
+// hashValue returns the representation of a fixture value hashed by Add.
+func hashValue(v any) string {
+	encoded, err := encodeValue(v)
+	if err != nil {
+		encoded = fmt.Sprint(v)
+	}
+
+	return fmt.Sprintf("%T:%v", v, encoded)
+}
+
+func (s *Set) parseYAML(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if line, ref, ok := findUnquotedReference(data); ok {
+			return fmt.Errorf("decode: line %d: %w, use %q instead of %s",
+				line, ErrUnquotedReference, ref, ref)
+		}
+
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil // empty document
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return errors.New("expected a mapping of table names to rows")
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		table, rows := root.Content[i].Value, root.Content[i+1]
+
+		fixtures, err := parseYAMLRows(rows)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+
+		s.add(table, fixtures...)
+	}
+
+	return nil
+}
+
+// findUnquotedReference returns the first unquoted reference in data along
+// with its line number.
+func findUnquotedReference(data []byte) (int, string, bool) {
+	loc := unquotedReferenceRe.FindSubmatchIndex(data)
+	if loc == nil {
+		return 0, "", false
+	}
+
+	return bytes.Count(data[:loc[2]], []byte("\n")) + 1, string(data[loc[2]:loc[3]]), true
+}
+
+func parseYAMLRows(node *yaml.Node) ([]Fixture, error) {
+	switch node.Kind { //nolint:exhaustive // other kinds are rejected.
+	case yaml.SequenceNode:
+		fixtures := make([]Fixture, 0, len(node.Content))
+
+		for _, n := range node.Content {
+			f, err := parseYAMLRow("", n)
+			if err != nil {
+				return nil, err
+			}
+
+			fixtures = append(fixtures, f)
+		}
+
+		return fixtures, nil
+	case yaml.MappingNode:
+		fixtures := make([]Fixture, 0, len(node.Content)/2)
+
+		for i := 0; i < len(node.Content); i += 2 {
+			f, err := parseYAMLRow(node.Content[i].Value, node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+
+			fixtures = append(fixtures, f)
+		}
+
+		return fixtures, nil
+	default:
+		return nil, errors.New("expected a list or a mapping of rows")
+	}
+}
+
+func parseYAMLRow(label string, node *yaml.Node) (Fixture, error) {
+	var values map[string]any
+	if err := node.Decode(&values); err != nil {
+		return Fixture{}, fmt.Errorf("decode row at line %d: %w", node.Line, err)
+	}
+
+	if values == nil {
+		values = make(map[string]any)
+	}
+
+	if l, ok := values[LabelColumn]; ok {
+		label = fmt.Sprint(l)
+		delete(values, LabelColumn)
+	}
+
+	return Fixture{Label: label, Values: values}, nil
+}
+
+func (s *Set) parseCSV(table string, data []byte) error {
+	r := csv.NewReader(bytes.NewReader(data))
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil // empty file
+	}
+
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+
+	var fixtures []Fixture
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("read record: %w", err)
+		}
+
+		f := Fixture{Label: "", Values: make(map[string]any, len(header))}
+
+		for i, col := range header {
+			switch {
+			case col == LabelColumn:
+				f.Label = record[i]
+			case record[i] == "":
+				f.Values[col] = nil
+			default:
+				f.Values[col] = record[i]
+			}
+		}
+
+		fixtures = append(fixtures, f)
+	}
+
+	s.add(table, fixtures...)
+
+	return nil
+}
+
+// encodeValue converts a fixture value into a form accepted for any column
+// type: strings are parsed by the server according to the column type.
+func encodeValue(v any) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return `\x` + hex.EncodeToString(v), nil
+	default:
+		// Mappings and lists are stored as JSON.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("encode %T value: %w", v, err)
+		}
+
+		return string(b), nil
+	}
+}
@@ -0,0 +1,227 @@
+package fixtures_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/fixtures"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+const librarySchema = `
+CREATE TABLE authors (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  name TEXT NOT NULL,
+  handle TEXT
+);
+CREATE TABLE books (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  title TEXT NOT NULL,
+  author_id BIGINT NOT NULL REFERENCES authors (id),
+  tags JSONB
+);`
+
+//nolint:gochecknoglobals // test data.
+var libraryFS = fstest.MapFS{
+	"books.yaml": {Data: []byte(`
+books:
+  - title: Wonderland
+    author_id: "@alice"
+    tags: [fantasy, classic]
+  - _label: looking_glass
+    title: Through the Looking-Glass
+    author_id: "@alice"
+`)},
+	"authors.csv": {Data: []byte("_label,name,handle\nalice,Alice,@@alice\nbob,Bob,\n")},
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it parses YAML and CSV fixtures", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		s, err := fixtures.Load(libraryFS, "books.yaml", "authors.csv")
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{"books", "authors"}, s.Tables())
+		assert.Equal(t, []fixtures.Fixture{
+			{Label: "alice", Values: map[string]any{"name": "Alice", "handle": "@@alice"}},
+			{Label: "bob", Values: map[string]any{"name": "Bob", "handle": nil}},
+		}, s.Fixtures("authors"))
+
+		books := s.Fixtures("books")
+		require.Len(t, books, 2)
+		assert.Empty(t, books[0].Label)
+		assert.Equal(t, "looking_glass", books[1].Label)
+		assert.NotContains(t, books[1].Values, fixtures.LabelColumn)
+	})
+
+	t.Run("it parses labeled mappings of rows from JSON", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		fsys := fstest.MapFS{"authors.json": {Data: []byte(`{"authors": {"alice": {"name": "Alice"}}}`)}}
+
+		// Act
+		s, err := fixtures.Load(fsys, "authors.json")
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []fixtures.Fixture{
+			{Label: "alice", Values: map[string]any{"name": "Alice"}},
+		}, s.Fixtures("authors"))
+	})
+
+	t.Run("it changes the hash with the contents", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		a, err := fixtures.Load(libraryFS, "books.yaml")
+		require.NoError(t, err)
+
+		b, err := fixtures.Load(libraryFS, "books.yaml", "authors.csv")
+		require.NoError(t, err)
+
+		// Act & Assert
+		assert.NotEqual(t, a.Hash(), b.Hash())
+	})
+
+	t.Run("it changes the hash with added fixtures", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		alice := fixtures.Fixture{Label: "alice", Values: map[string]any{"name": "Alice"}}
+		bob := fixtures.Fixture{Label: "alice", Values: map[string]any{"name": "Bob"}}
+
+		a, b, c := fixtures.New(), fixtures.New(), fixtures.New()
+
+		// Act
+		a.Add("authors", alice)
+		b.Add("authors", bob)
+		c.Add("authors", alice)
+
+		// Assert
+		assert.NotEqual(t, fixtures.New().Hash(), a.Hash())
+		assert.NotEqual(t, a.Hash(), b.Hash())
+		assert.Equal(t, a.Hash(), c.Hash())
+	})
+
+	t.Run("it rejects unsupported formats", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		fsys := fstest.MapFS{"authors.toml": {Data: []byte("")}}
+
+		// Act
+		_, err := fixtures.Load(fsys, "authors.toml")
+
+		// Assert
+		require.ErrorContains(t, err, "unsupported format")
+	})
+
+	t.Run("it rejects unquoted references in YAML", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		data := "books:\n  - title: Wonderland\n    author_id: @alice\n"
+		fsys := fstest.MapFS{"books.yaml": {Data: []byte(data)}}
+
+		// Act
+		_, err := fixtures.Load(fsys, "books.yaml")
+
+		// Assert
+		require.ErrorIs(t, err, fixtures.ErrUnquotedReference)
+		assert.ErrorContains(t, err, `line 3`)
+		assert.ErrorContains(t, err, `use "@alice" instead of @alice`)
+	})
+}
+
+func TestSetInsert(t *testing.T) {
+	t.Parallel()
+
+	f, err := pgxephemeraltest.NewPoolFactory(
+		t.Context(),
+		testutil.PoolConfig(t),
+		testutil.NewMigrator(librarySchema, "fixtures-library"),
+	)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	s, err := fixtures.Load(libraryFS, "books.yaml", "authors.csv")
+	require.NoError(t, err)
+
+	t.Run("it inserts fixtures in dependency order resolving references", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+		// Act
+		labels := s.MustInsert(t, tx)
+
+		// Assert
+		var count int
+
+		err := tx.QueryRow(
+			t.Context(),
+			"SELECT count(*) FROM books WHERE author_id = $1 AND tags = '[\"fantasy\", \"classic\"]'",
+			labels["alice"]["id"],
+		).Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		var handle *string
+
+		err = tx.QueryRow(t.Context(), "SELECT handle FROM authors WHERE name = 'Alice'").Scan(&handle)
+		require.NoError(t, err)
+		require.NotNil(t, handle)
+		assert.Equal(t, "@alice", *handle)
+		assert.Equal(t, "Through the Looking-Glass", labels["looking_glass"]["title"])
+	})
+
+	t.Run("it reports unknown labels", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+		s := fixtures.New()
+		s.Add("books", fixtures.Fixture{
+			Label:  "",
+			Values: map[string]any{"title": "Orphan", "author_id": "@nobody"},
+		})
+
+		// Act
+		_, err := s.Insert(t.Context(), tx)
+
+		// Assert
+		require.ErrorIs(t, err, fixtures.ErrUnknownLabel)
+	})
+
+	t.Run("it bakes fixtures into a template", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		m := migrators.Chain(testutil.NewMigrator(librarySchema, "fixtures-library"), s)
+		pf, err := pgxephemeraltest.NewPoolFactory(t.Context(), testutil.PoolConfig(t), m)
+		require.NoError(t, err)
+		t.Cleanup(pf.Close)
+
+		// Act
+		pool := pf.Pool(t)
+
+		// Assert
+		var count int
+
+		err = pool.QueryRow(t.Context(), "SELECT count(*) FROM books").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+}
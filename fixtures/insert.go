@@ -0,0 +1,337 @@
+package fixtures
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/querytag"
+)
+
+// ErrUnknownLabel is returned when a reference points to a label that is not
+// defined by any of the previously inserted fixtures.
+var ErrUnknownLabel = errors.New("unknown label")
+
+// Querier is the interface common to pgx.Conn, pgx.Tx and pgxpool.Pool
+// used to insert fixtures.
+type Querier interface {
+	Query(context.Context, string, ...any) (pgx.Rows, error)
+	CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error)
+}
+
+// Labels holds inserted labeled rows keyed by label. Rows include column
+// values assigned by the database, such as generated primary keys.
+type Labels map[string]map[string]any
+
+// labeledRow is an inserted labeled row.
+type labeledRow struct {
+	table catalog.Table
+	pk    []string
+	row   map[string]any
+}
+
+// Migrate inserts the fixtures, it implements pgxephemeraltest.Migrator.
+func (s *Set) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	_, err := s.Insert(ctx, conn)
+
+	return err
+}
+
+// MustInsert is like Insert, but fails the test on error.
+func (s *Set) MustInsert(tb internaltesting.TB, q Querier) Labels {
+	tb.Helper()
+
+	labels, err := s.Insert(tb.Context(), q)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return labels
+}
+
+// Insert inserts the fixtures in the order of foreign key dependencies
+// between their tables.
+//
+// Labeled rows are inserted one by one to make values assigned by the database
+// available to references, the rest is bulk-loaded with CopyFrom. Within
+// a table, labeled rows are inserted first, in their definition order.
+func (s *Set) Insert(ctx context.Context, q Querier) (Labels, error) {
+	ctx = querytag.Internal(ctx)
+
+	tables, err := s.resolveTables(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	labeled := make(map[string]*labeledRow)
+
+	for _, t := range tables {
+		if err := s.insertTable(ctx, q, t, labeled); err != nil {
+			return nil, fmt.Errorf("fixtures: insert into %s: %w", t.table.Key(), err)
+		}
+	}
+
+	labels := make(Labels, len(labeled))
+	for label, r := range labeled {
+		labels[label] = r.row
+	}
+
+	return labels, nil
+}
+
+// resolvedTable is a table with its fixtures.
+type resolvedTable struct {
+	table    catalog.Table
+	fixtures []Fixture
+}
+
+func (s *Set) resolveTables(ctx context.Context, q Querier) ([]resolvedTable, error) {
+	fixtures := make(map[catalog.Table][]Fixture, len(s.order))
+	tables := make([]catalog.Table, 0, len(s.order))
+
+	for _, name := range s.order {
+		t, err := catalog.ResolveTable(ctx, q, name)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: %w", err)
+		}
+
+		if _, ok := fixtures[t]; !ok {
+			tables = append(tables, t)
+		}
+
+		fixtures[t] = append(fixtures[t], s.tables[name]...)
+	}
+
+	tables, err := catalog.SortByDependencies(ctx, q, tables)
+	if err != nil {
+		return nil, fmt.Errorf("fixtures: failed to order tables: %w", err)
+	}
+
+	ret := make([]resolvedTable, len(tables))
+	for i, t := range tables {
+		ret[i] = resolvedTable{table: t, fixtures: fixtures[t]}
+	}
+
+	return ret, nil
+}
+
+func (s *Set) insertTable(
+	ctx context.Context,
+	q Querier,
+	t resolvedTable,
+	labeled map[string]*labeledRow,
+) error {
+	var pk []string
+
+	var unlabeled []Fixture
+
+	for _, f := range t.fixtures {
+		if f.Label == "" {
+			unlabeled = append(unlabeled, f)
+			continue
+		}
+
+		if _, ok := labeled[f.Label]; ok {
+			return fmt.Errorf("duplicate label %q", f.Label)
+		}
+
+		if pk == nil {
+			var err error
+			if pk, err = catalog.PrimaryKey(ctx, q, t.table); err != nil {
+				return err //nolint:wrapcheck // wrapped by the caller.
+			}
+		}
+
+		row, err := insertRow(ctx, q, t.table, f, labeled)
+		if err != nil {
+			return fmt.Errorf("row %q: %w", f.Label, err)
+		}
+
+		labeled[f.Label] = &labeledRow{table: t.table, pk: pk, row: row}
+	}
+
+	return copyRows(ctx, q, t.table, unlabeled, labeled)
+}
+
+func insertRow(
+	ctx context.Context,
+	q Querier,
+	t catalog.Table,
+	f Fixture,
+	labeled map[string]*labeledRow,
+) (map[string]any, error) {
+	cols := slices.Sorted(maps.Keys(f.Values))
+
+	values, err := rowValues(f, cols, labeled)
+	if err != nil {
+		return nil, err
+	}
+
+	var sql strings.Builder
+
+	sql.WriteString("INSERT INTO ")
+	sql.WriteString(t.Identifier().Sanitize())
+	sql.WriteString(" AS t")
+
+	if len(cols) == 0 {
+		sql.WriteString(" DEFAULT VALUES")
+	} else {
+		params := make([]string, len(cols))
+		for i := range cols {
+			params[i] = fmt.Sprintf("$%d", i+1)
+		}
+
+		fmt.Fprintf(&sql, " (%s) VALUES (%s)", catalog.QuoteIdentifiers(cols), strings.Join(params, ", "))
+	}
+
+	sql.WriteString(" RETURNING to_jsonb(t.*)::text")
+
+	rows, err := q.Query(ctx, sql.String(), values...)
+	if err != nil {
+		return nil, fmt.Errorf("insert row: %w", err)
+	}
+
+	raw, err := pgx.CollectExactlyOneRow(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("insert row: %w", err)
+	}
+
+	var row map[string]any
+
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+
+	if err := dec.Decode(&row); err != nil {
+		return nil, fmt.Errorf("decode inserted row: %w", err)
+	}
+
+	return row, nil
+}
+
+// copyRows bulk-loads fixtures grouping them by the set of columns.
+func copyRows(
+	ctx context.Context,
+	q Querier,
+	t catalog.Table,
+	fixtures []Fixture,
+	labeled map[string]*labeledRow,
+) error {
+	type group struct {
+		cols []string
+		rows [][]any
+	}
+
+	var groups []*group
+
+	byCols := make(map[string]*group)
+
+	for _, f := range fixtures {
+		cols := slices.Sorted(maps.Keys(f.Values))
+
+		values, err := rowValues(f, cols, labeled)
+		if err != nil {
+			return err
+		}
+
+		key := strings.Join(cols, "\x00")
+
+		g, ok := byCols[key]
+		if !ok {
+			g = &group{cols: cols, rows: nil}
+			byCols[key] = g
+			groups = append(groups, g)
+		}
+
+		g.rows = append(g.rows, values)
+	}
+
+	for _, g := range groups {
+		if len(g.cols) == 0 {
+			// COPY requires at least one column.
+			empty := Fixture{Label: "", Values: nil}
+
+			for range g.rows {
+				if _, err := insertRow(ctx, q, t, empty, labeled); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		if _, err := q.CopyFrom(ctx, t.Identifier(), g.cols, pgx.CopyFromRows(g.rows)); err != nil {
+			return fmt.Errorf("copy rows: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rowValues returns values of the cols columns of f, resolving references.
+func rowValues(f Fixture, cols []string, labeled map[string]*labeledRow) ([]any, error) {
+	values := make([]any, len(cols))
+
+	for i, col := range cols {
+		v := f.Values[col]
+
+		if s, ok := v.(string); ok {
+			var err error
+			if v, err = resolveReference(s, labeled); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col, err)
+			}
+		}
+
+		var err error
+		if values[i], err = encodeValue(v); err != nil {
+			return nil, fmt.Errorf("column %s: %w", col, err)
+		}
+	}
+
+	return values, nil
+}
+
+// resolveReference resolves "@label" and "@label.column" references to values
+// of the labeled rows. Other strings are returned as is, except for the
+// escaped leading "@@".
+func resolveReference(s string, labeled map[string]*labeledRow) (any, error) {
+	if !strings.HasPrefix(s, "@") {
+		return s, nil
+	}
+
+	if strings.HasPrefix(s, "@@") {
+		return s[1:], nil
+	}
+
+	label, col, hasCol := strings.Cut(s[1:], ".")
+
+	r, ok := labeled[label]
+	if !ok {
+		return nil, fmt.Errorf("reference %s: %w %q", s, ErrUnknownLabel, label)
+	}
+
+	if !hasCol {
+		if len(r.pk) != 1 {
+			return nil, fmt.Errorf(
+				"reference %s: table %s has no single-column primary key, use @%s.<column>",
+				s, r.table.Key(), label,
+			)
+		}
+
+		col = r.pk[0]
+	}
+
+	v, ok := r.row[col]
+	if !ok {
+		return nil, fmt.Errorf("reference %s: table %s has no column %q", s, r.table.Key(), col)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,68 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+// TemplateGCPolicy defines which templates are kept by template garbage
+// collection. A template is kept if it is one of the KeepLatest most
+// recently used templates of its lineage, or it was used within the TTL.
+//
+// The lineage of a template identifies the migrator it is built by, so
+// successive versions of migrations share the lineage. Templates have no
+// lineage unless the migrator reports one or it is set by
+// WithTemplateLineage, and templates without a lineage are only collected
+// by TTL, as they may belong to other projects.
+type TemplateGCPolicy = dbmanager.GCPolicy
+
+// WithTemplateGC makes PoolFactory drop stale templates according to
+// the policy on creation. The template of the factory is never dropped.
+//
+// Every migration change produces a new template, so without collection old
+// templates pile up on long-lived database servers.
+func WithTemplateGC(policy TemplateGCPolicy) FactoryOption {
+	return func(config *factoryOptions) { config.templateGC = &policy }
+}
+
+// WithTemplateLineage sets the lineage of templates built by the factory,
+// which is used to group successive versions of templates by template
+// garbage collection. Factories of different projects or purposes must use
+// distinct lineages, otherwise they collect the templates of each other.
+func WithTemplateLineage(lineage string) FactoryOption {
+	return func(config *factoryOptions) { config.templateLineage = lineage }
+}
+
+// lineageMigrator overrides the lineage of the migrator.
+type lineageMigrator struct {
+	Migrator
+
+	lineage string
+}
+
+func (m lineageMigrator) Lineage() string { return m.lineage }
+
+// withLineage returns the migrator with the lineage set by options, if any.
+func (p *factoryOptions) withLineage(migrator Migrator) Migrator {
+	if p.templateLineage == "" {
+		return migrator
+	}
+
+	return lineageMigrator{Migrator: migrator, lineage: p.templateLineage}
+}
+
+// collectTemplates drops templates not kept by the GC policy of options,
+// except the keep templates.
+func collectTemplates(ctx context.Context, m *dbmanager.DBManager, options factoryOptions, keep ...string) error {
+	if options.templateGC == nil {
+		return nil
+	}
+
+	if _, err := m.CollectTemplates(ctx, *options.templateGC, keep...); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to collect stale templates: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package pgxephemeraltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+// UpdateGoldenEnv is the environment variable that, if set to a non-empty
+// value, makes AssertGolden rewrite golden files, like the
+// -pgxephemeraltest.update flag does.
+const UpdateGoldenEnv = "PGXEPHEMERALTEST_UPDATE_GOLDEN"
+
+//nolint:gochecknoglobals // flags are global by design.
+var updateGolden = flag.Bool(
+	"pgxephemeraltest.update",
+	false,
+	"rewrite golden files compared by pgxephemeraltest.AssertGolden",
+)
+
+// AssertGolden asserts that contents of the given tables, or of all user tables
+// if none are given, match the golden file at path.
+//
+// Tables are serialized deterministically: ordered by name, rows ordered by
+// primary key or, for tables without one, by their serialized form,
+// timestamps with time zone converted to UTC, JSON objects with sorted keys,
+// and numeric and bytea values kept in their Postgres text form.
+//
+// Run tests with the -pgxephemeraltest.update flag (or UpdateGoldenEnv set)
+// to rewrite golden files instead of comparing them.
+func AssertGolden(tb internaltesting.TB, q Querier, path string, tables ...string) {
+	tb.Helper()
+
+	s, err := Snapshot(tb.Context(), q, tables...)
+	assertNoError(tb, err, "pgxephemeraltest: failed to snapshot tables")
+
+	actual, err := FormatGolden(s)
+	assertNoError(tb, err, "pgxephemeraltest: failed to format golden file")
+
+	if *updateGolden || os.Getenv(UpdateGoldenEnv) != "" {
+		err := os.MkdirAll(filepath.Dir(path), 0o755) //nolint:mnd // default permissions for test data.
+		assertNoError(tb, err, "pgxephemeraltest: failed to create golden file directory")
+
+		err = os.WriteFile(path, actual, 0o644) //nolint:gosec,mnd // golden files are not sensitive.
+		assertNoError(tb, err, "pgxephemeraltest: failed to write golden file")
+
+		tb.Logf("pgxephemeraltest: updated golden file: %s", path)
+
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		tb.Fatalf(
+			"pgxephemeraltest: golden file %s does not exist, run tests with -pgxephemeraltest.update to create it",
+			path,
+		)
+
+		return
+	}
+
+	assertNoError(tb, err, "pgxephemeraltest: failed to read golden file")
+
+	if bytes.Equal(expected, actual) {
+		return
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expected)),
+		B:        difflib.SplitLines(string(actual)),
+		FromFile: path,
+		FromDate: "",
+		ToFile:   "actual",
+		ToDate:   "",
+		Eol:      "",
+		Context:  3, //nolint:mnd // conventional amount of diff context.
+	})
+	assertNoError(tb, err, "pgxephemeraltest: failed to diff golden file")
+
+	tb.Errorf("pgxephemeraltest: database state does not match golden file %s:\n%s", path, diff)
+}
+
+// FormatGolden serializes the snapshot in the golden file format used by
+// AssertGolden.
+func FormatGolden(s *DataSnapshot) ([]byte, error) {
+	var b bytes.Buffer
+
+	for i, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		if err := formatGoldenTable(&b, s.Tables[name]); err != nil {
+			return nil, fmt.Errorf("format table %s: %w", name, err)
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+func formatGoldenTable(b *bytes.Buffer, ts *TableSnapshot) error {
+	cols := make([]string, len(ts.Columns))
+	for i, col := range ts.Columns {
+		cols[i] = col + " " + ts.ColumnTypes[i]
+	}
+
+	fmt.Fprintf(b, "# %s (%s)\n", ts.Name, strings.Join(cols, ", "))
+
+	lines := make([]string, len(ts.Rows))
+
+	for i, row := range ts.Rows {
+		parts := make([]string, len(ts.Columns))
+
+		for j, col := range ts.Columns {
+			v, err := formatGoldenValue(row[col], ts.ColumnTypes[j])
+			if err != nil {
+				return fmt.Errorf("format column %s: %w", col, err)
+			}
+
+			parts[j] = col + "=" + v
+		}
+
+		lines[i] = strings.Join(parts, " ")
+	}
+
+	// Rows of tables without a primary key have no meaningful order,
+	// so they are sorted by their serialized form.
+	if len(ts.PrimaryKey) == 0 {
+		slices.Sort(lines)
+	}
+
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	return nil
+}
+
+func formatGoldenValue(v any, typ string) (string, error) {
+	if v == nil {
+		return "NULL", nil
+	}
+
+	// Timestamps are rendered in the session time zone, normalize them
+	// to UTC. Special values, like infinity, are kept as is.
+	if s, ok := v.(string); ok && typ == "timestamp with time zone" {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			v = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	// encoding/json sorts map keys and keeps json.Number as is, which makes
+	// JSON and numeric values stable.
+	var b bytes.Buffer
+
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return "", fmt.Errorf("marshal value: %w", err)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
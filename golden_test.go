@@ -0,0 +1,98 @@
+package pgxephemeraltest_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestFormatGolden(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	s := &pgxephemeraltest.DataSnapshot{Tables: map[string]*pgxephemeraltest.TableSnapshot{
+		"users": {
+			Name:        "users",
+			Columns:     []string{"id", "meta", "created_at", "deleted_at"},
+			ColumnTypes: []string{"integer", "jsonb", "timestamp with time zone", "timestamp with time zone"},
+			PrimaryKey:  []string{"id"},
+			Rows: []pgxephemeraltest.Row{{
+				"id":         int64(1),
+				"meta":       map[string]any{"z": true, "a": "<b>"},
+				"created_at": "2024-01-01T03:00:00.5+03:00",
+				"deleted_at": nil,
+			}},
+		},
+		"audit.events": {
+			Name:        "audit.events",
+			Columns:     []string{"id"},
+			ColumnTypes: []string{"bigint"},
+			PrimaryKey:  []string{"id"},
+			Rows:        nil,
+		},
+	}}
+
+	// Act
+	out, err := pgxephemeraltest.FormatGolden(s)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, `# audit.events (id bigint)
+
+# users (id integer, meta jsonb, created_at timestamp with time zone, deleted_at timestamp with time zone)
+id=1 meta={"a":"<b>","z":true} created_at="2024-01-01T00:00:00.5Z" deleted_at=NULL
+`, string(out))
+}
+
+func TestFormatGolden_NoPrimaryKey(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	s := &pgxephemeraltest.DataSnapshot{Tables: map[string]*pgxephemeraltest.TableSnapshot{
+		"events": {
+			Name:        "events",
+			Columns:     []string{"name", "amount"},
+			ColumnTypes: []string{"text", "numeric"},
+			PrimaryKey:  nil,
+			Rows: []pgxephemeraltest.Row{
+				{"name": "b", "amount": json.Number("1.50")},
+				{"name": "a", "amount": int64(2)},
+				{"name": "a", "amount": int64(1)},
+			},
+		},
+	}}
+
+	// Act
+	out, err := pgxephemeraltest.FormatGolden(s)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, `# events (name text, amount numeric)
+name="a" amount=1
+name="a" amount=2
+name="b" amount=1.50
+`, string(out))
+}
+
+func TestAssertGolden(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	f, err := pgxephemeraltest.NewPoolFactory(t.Context(), testutil.PoolConfig(t), testutil.NewKVMigrator())
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+	// Act
+	_, err = tx.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ('b', '<2>'), ('a', '1')")
+	require.NoError(t, err)
+
+	// Assert
+	pgxephemeraltest.AssertGolden(t, tx, "testdata/kv.golden")
+}
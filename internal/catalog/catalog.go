@@ -0,0 +1,348 @@
+// Package catalog introspects user objects of a Postgres database.
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// InternalSchema is the schema holding objects created by pgxephemeraltest
+// itself. It is never reported as a user schema.
+const InternalSchema = "pgxephemeraltest"
+
+// userSchemaFilter is an SQL condition excluding system and internal schemas
+// referred to by the n alias.
+const userSchemaFilter = `n.nspname NOT IN ('pg_catalog', 'information_schema', '` + InternalSchema + `')
+  AND n.nspname NOT LIKE 'pg\_toast%'
+  AND n.nspname NOT LIKE 'pg\_temp%'`
+
+// ErrTableNotFound is returned when a table cannot be resolved.
+var ErrTableNotFound = errors.New("table not found")
+
+// Querier is the interface common to pgx.Conn, pgx.Tx and pgxpool.Pool
+// used for introspection.
+type Querier interface {
+	Query(context.Context, string, ...any) (pgx.Rows, error)
+}
+
+// Table identifies a table.
+type Table struct {
+	Schema string
+	Name   string
+}
+
+// Identifier returns the table identifier suitable for sanitizing.
+func (t Table) Identifier() pgx.Identifier { return pgx.Identifier{t.Schema, t.Name} }
+
+// Key returns the name used to refer to the table in results. Tables in
+// the public schema are referred to by their name, the rest is qualified
+// with the schema name.
+func (t Table) Key() string {
+	if t.Schema == "public" {
+		return t.Name
+	}
+
+	return t.Schema + "." + t.Name
+}
+
+// Column describes a table column.
+type Column struct {
+	Name string
+	// Type is the column type as formatted by format_type.
+	Type string
+	// Category is the pg_type.typcategory of the column type, e.g., "N"
+	// for numeric types or "S" for string types.
+	Category   string
+	NotNull    bool
+	HasDefault bool
+	// Generated indicates an identity or generated column.
+	Generated bool
+	// MaxLength is the declared length of a character column, zero if
+	// the length is not limited.
+	MaxLength int
+}
+
+// UserTables returns ordinary and partitioned tables in user schemas, skipping
+// partitions and tables owned by extensions.
+func UserTables(ctx context.Context, q Querier) ([]Table, error) {
+	rows, err := q.Query(ctx, `
+SELECT n.nspname, c.relname
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p')
+  AND NOT c.relispartition
+  AND `+userSchemaFilter+`
+  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'e')
+ORDER BY n.nspname, c.relname`)
+	if err != nil {
+		return nil, fmt.Errorf("list user tables: %w", err)
+	}
+
+	tables, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Table, error) {
+		var t Table
+		err := row.Scan(&t.Schema, &t.Name)
+
+		return t, err //nolint:wrapcheck // wrapped below.
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list user tables: %w", err)
+	}
+
+	return tables, nil
+}
+
+// ResolveTable resolves a possibly schema-qualified table name using
+// the current search path.
+func ResolveTable(ctx context.Context, q Querier, name string) (Table, error) {
+	rows, err := q.Query(ctx, `
+SELECT n.nspname, c.relname
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.oid = to_regclass($1)`, name)
+	if err != nil {
+		return Table{}, fmt.Errorf("resolve table %q: %w", name, err)
+	}
+
+	t, err := pgx.CollectExactlyOneRow(rows, func(row pgx.CollectableRow) (Table, error) {
+		var t Table
+		err := row.Scan(&t.Schema, &t.Name)
+
+		return t, err //nolint:wrapcheck // wrapped below.
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Table{}, fmt.Errorf("resolve table %q: %w", name, ErrTableNotFound)
+	}
+
+	if err != nil {
+		return Table{}, fmt.Errorf("resolve table %q: %w", name, err)
+	}
+
+	return t, nil
+}
+
+// ResolveTables resolves table names, or returns all user tables if no
+// names are given.
+func ResolveTables(ctx context.Context, q Querier, names ...string) ([]Table, error) {
+	if len(names) == 0 {
+		return UserTables(ctx, q)
+	}
+
+	tables := make([]Table, 0, len(names))
+
+	for _, name := range names {
+		t, err := ResolveTable(ctx, q, name)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, t)
+	}
+
+	return tables, nil
+}
+
+// PrimaryKey returns primary key columns of the table in key order, or nil
+// if the table has no primary key.
+func PrimaryKey(ctx context.Context, q Querier, t Table) ([]string, error) {
+	rows, err := q.Query(ctx, `
+SELECT a.attname
+FROM pg_index i
+CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+WHERE i.indrelid = $1::regclass AND i.indisprimary
+ORDER BY k.ord`, t.Identifier().Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("query primary key of %s: %w", t.Key(), err)
+	}
+
+	cols, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("query primary key of %s: %w", t.Key(), err)
+	}
+
+	return cols, nil
+}
+
+// Columns returns columns of the table in their definition order.
+func Columns(ctx context.Context, q Querier, t Table) ([]Column, error) {
+	rows, err := q.Query(ctx, `
+SELECT
+  a.attname,
+  format_type(a.atttypid, a.atttypmod),
+  t.typcategory::text,
+  a.attnotnull,
+  a.atthasdef,
+  a.attidentity <> '' OR a.attgenerated <> '',
+  CASE
+    WHEN a.atttypid IN ('varchar'::regtype, 'bpchar'::regtype) AND a.atttypmod > 0 THEN a.atttypmod - 4
+    ELSE 0
+  END
+FROM pg_attribute a
+JOIN pg_type t ON t.oid = a.atttypid
+WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`, t.Identifier().Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("query columns of %s: %w", t.Key(), err)
+	}
+
+	cols, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Column, error) {
+		var c Column
+		err := row.Scan(&c.Name, &c.Type, &c.Category, &c.NotNull, &c.HasDefault, &c.Generated, &c.MaxLength)
+
+		return c, err //nolint:wrapcheck // wrapped below.
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query columns of %s: %w", t.Key(), err)
+	}
+
+	return cols, nil
+}
+
+// QuoteIdentifiers sanitizes each of the column names and joins them
+// with a comma.
+func QuoteIdentifiers(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pgx.Identifier{name}.Sanitize()
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// ForeignKey describes a foreign key constraint.
+type ForeignKey struct {
+	Name       string
+	Table      Table
+	Columns    []string
+	RefTable   Table
+	RefColumns []string
+}
+
+// ForeignKeys returns foreign keys defined on the table ordered by name.
+func ForeignKeys(ctx context.Context, q Querier, t Table) ([]ForeignKey, error) {
+	rows, err := q.Query(ctx, `
+SELECT
+  con.conname,
+  cn.nspname,
+  c.relname,
+  ARRAY(
+    SELECT a.attname
+    FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+    JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+    ORDER BY k.ord
+  ),
+  rn.nspname,
+  r.relname,
+  ARRAY(
+    SELECT a.attname
+    FROM unnest(con.confkey) WITH ORDINALITY AS k(attnum, ord)
+    JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+    ORDER BY k.ord
+  )
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace cn ON cn.oid = c.relnamespace
+JOIN pg_class r ON r.oid = con.confrelid
+JOIN pg_namespace rn ON rn.oid = r.relnamespace
+WHERE con.contype = 'f' AND con.conrelid = $1::regclass
+ORDER BY con.conname`, t.Identifier().Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("query foreign keys of %s: %w", t.Key(), err)
+	}
+
+	fks, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ForeignKey, error) {
+		var fk ForeignKey
+		err := row.Scan(
+			&fk.Name,
+			&fk.Table.Schema,
+			&fk.Table.Name,
+			&fk.Columns,
+			&fk.RefTable.Schema,
+			&fk.RefTable.Name,
+			&fk.RefColumns,
+		)
+
+		return fk, err //nolint:wrapcheck // wrapped below.
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query foreign keys of %s: %w", t.Key(), err)
+	}
+
+	return fks, nil
+}
+
+// SortByDependencies orders tables so that tables referenced by foreign keys
+// come before tables referencing them. Self-references and references to
+// tables outside of the given set are ignored. Tables are otherwise ordered
+// by name.
+//
+// It returns an error if the foreign keys form a cycle.
+func SortByDependencies(ctx context.Context, q Querier, tables []Table) ([]Table, error) {
+	var fks []ForeignKey
+
+	for _, t := range tables {
+		tfks, err := ForeignKeys(ctx, q, t)
+		if err != nil {
+			return nil, err
+		}
+
+		fks = append(fks, tfks...)
+	}
+
+	return sortTables(tables, fks)
+}
+
+// sortTables orders tables topologically according to fks.
+func sortTables(tables []Table, fks []ForeignKey) ([]Table, error) {
+	deps := make(map[Table]map[Table]struct{}, len(tables))
+	for _, t := range tables {
+		deps[t] = make(map[Table]struct{})
+	}
+
+	for _, fk := range fks {
+		if _, ok := deps[fk.Table]; !ok {
+			continue
+		}
+
+		if _, ok := deps[fk.RefTable]; ok && fk.RefTable != fk.Table {
+			deps[fk.Table][fk.RefTable] = struct{}{}
+		}
+	}
+
+	pending := slices.Clone(tables)
+	slices.SortFunc(pending, func(a, b Table) int { return strings.Compare(a.Key(), b.Key()) })
+
+	sorted := make([]Table, 0, len(tables))
+	done := make(map[Table]struct{}, len(tables))
+
+	for len(pending) > 0 {
+		i := slices.IndexFunc(pending, func(t Table) bool {
+			for dep := range deps[t] {
+				if _, ok := done[dep]; !ok {
+					return false
+				}
+			}
+
+			return true
+		})
+		if i < 0 {
+			keys := make([]string, len(pending))
+			for i, t := range pending {
+				keys[i] = t.Key()
+			}
+
+			return nil, fmt.Errorf("foreign keys form a cycle between tables: %s", strings.Join(keys, ", "))
+		}
+
+		sorted = append(sorted, pending[i])
+		done[pending[i]] = struct{}{}
+		pending = slices.Delete(pending, i, i+1)
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,142 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestSortTables(t *testing.T) {
+	t.Parallel()
+
+	var (
+		authors  = Table{Schema: "public", Name: "authors"}
+		books    = Table{Schema: "public", Name: "books"}
+		reviews  = Table{Schema: "public", Name: "reviews"}
+		external = Table{Schema: "public", Name: "external"}
+	)
+
+	t.Run("it orders referenced tables first", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		fks := []ForeignKey{
+			fk(reviews, "book_id", books),
+			fk(books, "author_id", authors),
+			fk(authors, "mentor_id", authors),
+			fk(books, "ext_id", external),
+		}
+
+		// Act
+		sorted, err := sortTables([]Table{reviews, books, authors}, fks)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []Table{authors, books, reviews}, sorted)
+	})
+
+	t.Run("it rejects cycles", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		fks := []ForeignKey{fk(books, "author_id", authors), fk(authors, "book_id", books)}
+
+		// Act
+		_, err := sortTables([]Table{books, authors}, fks)
+
+		// Assert
+		require.ErrorContains(t, err, "cycle")
+	})
+}
+
+func fk(t Table, column string, ref Table) ForeignKey {
+	return ForeignKey{
+		Name:       t.Name + "_" + column + "_fkey",
+		Table:      t,
+		Columns:    []string{column},
+		RefTable:   ref,
+		RefColumns: []string{"id"},
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	conn, err := pgx.Connect(t.Context(), testutil.ConnString(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close(t.Context()) })
+
+	tx, err := conn.Begin(t.Context())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(t.Context()) })
+
+	_, err = tx.Exec(t.Context(), `
+CREATE SCHEMA catalog_test;
+CREATE TABLE catalog_test.authors (id SERIAL PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE catalog_test.books (
+  id INT GENERATED ALWAYS AS IDENTITY,
+  isbn TEXT,
+  author_id INT NOT NULL REFERENCES catalog_test.authors (id),
+  PRIMARY KEY (isbn, id)
+);`)
+	require.NoError(t, err)
+
+	// Act
+	books, err := ResolveTable(t.Context(), tx, "catalog_test.books")
+	require.NoError(t, err)
+
+	pk, err := PrimaryKey(t.Context(), tx, books)
+	require.NoError(t, err)
+
+	cols, err := Columns(t.Context(), tx, books)
+	require.NoError(t, err)
+
+	fks, err := ForeignKeys(t.Context(), tx, books)
+	require.NoError(t, err)
+
+	_, missingErr := ResolveTable(t.Context(), tx, "catalog_test.missing")
+
+	// Assert
+	assert.Equal(t, Table{Schema: "catalog_test", Name: "books"}, books)
+	assert.Equal(t, "catalog_test.books", books.Key())
+	assert.Equal(t, []string{"isbn", "id"}, pk)
+	assert.Equal(t, []Column{
+		{
+			Name:       "id",
+			Type:       "integer",
+			Category:   "N",
+			NotNull:    true,
+			HasDefault: false,
+			Generated:  true,
+			MaxLength:  0,
+		},
+		{
+			Name:       "isbn",
+			Type:       "text",
+			Category:   "S",
+			NotNull:    true,
+			HasDefault: false,
+			Generated:  false,
+			MaxLength:  0,
+		},
+		{
+			Name:       "author_id",
+			Type:       "integer",
+			Category:   "N",
+			NotNull:    true,
+			HasDefault: false,
+			Generated:  false,
+			MaxLength:  0,
+		},
+	}, cols)
+	require.Len(t, fks, 1)
+	assert.Equal(t, []string{"author_id"}, fks[0].Columns)
+	assert.Equal(t, Table{Schema: "catalog_test", Name: "authors"}, fks[0].RefTable)
+	assert.Equal(t, []string{"id"}, fks[0].RefColumns)
+	require.ErrorIs(t, missingErr, ErrTableNotFound)
+}
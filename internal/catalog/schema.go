@@ -0,0 +1,272 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// notExtensionMember is an SQL condition excluding objects owned by
+// extensions, where catalog is the system catalog of the object and oid
+// is the expression of its oid.
+func notExtensionMember(catalog, oid string) string {
+	return `NOT EXISTS (
+    SELECT 1 FROM pg_depend d
+    WHERE d.classid = '` + catalog + `'::regclass AND d.objid = ` + oid + ` AND d.deptype = 'e'
+  )`
+}
+
+// schemaQueries are queries describing user objects by kind. Each query
+// returns object names and definitions.
+//
+//nolint:gochecknoglobals // constant value.
+var schemaQueries = []struct {
+	kind string
+	sql  string
+}{
+	{"schema", `
+SELECT format('%I', n.nspname), ''
+FROM pg_namespace n
+WHERE ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_namespace", "n.oid")},
+	{"table", `
+SELECT format('%I.%I', n.nspname, c.relname), CASE c.relkind WHEN 'p' THEN 'partitioned' ELSE '' END
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p')
+  AND ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "c.oid")},
+	{"column", `
+SELECT
+  format('%I.%I.%I', n.nspname, c.relname, a.attname),
+  concat_ws(' ',
+    format_type(a.atttypid, a.atttypmod),
+    CASE WHEN a.attnotnull THEN 'NOT NULL' END,
+    CASE a.attidentity
+      WHEN 'a' THEN 'GENERATED ALWAYS AS IDENTITY'
+      WHEN 'd' THEN 'GENERATED BY DEFAULT AS IDENTITY'
+    END,
+    CASE WHEN a.attgenerated <> '' THEN 'GENERATED ALWAYS AS (' || pg_get_expr(ad.adbin, ad.adrelid) || ') STORED'
+         WHEN ad.adbin IS NOT NULL THEN 'DEFAULT ' || pg_get_expr(ad.adbin, ad.adrelid) END
+  )
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+  AND a.attnum > 0 AND NOT a.attisdropped
+  AND ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "c.oid")},
+	{"view", `
+SELECT
+  format('%I.%I', n.nspname, c.relname),
+  CASE c.relkind WHEN 'm' THEN 'MATERIALIZED ' ELSE '' END || pg_get_viewdef(c.oid)
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('v', 'm')
+  AND ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "c.oid")},
+	{"sequence", `
+SELECT
+  format('%I.%I', n.nspname, c.relname),
+  format('%s START %s INCREMENT %s', format_type(s.seqtypid, NULL), s.seqstart, s.seqincrement)
+FROM pg_sequence s
+JOIN pg_class c ON c.oid = s.seqrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "c.oid")},
+	{"index", `
+SELECT format('%I.%I', n.nspname, c.relname), pg_get_indexdef(c.oid)
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "i.indrelid")},
+	{"constraint", `
+SELECT format('%I.%I.%I', n.nspname, c.relname, con.conname), pg_get_constraintdef(con.oid)
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "c.oid")},
+	{"function", `
+SELECT
+  format('%I.%I(%s)', n.nspname, p.proname, pg_get_function_identity_arguments(p.oid)),
+  pg_get_functiondef(p.oid)
+FROM pg_proc p
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE p.prokind IN ('f', 'p')
+  AND ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_proc", "p.oid")},
+	{"trigger", `
+SELECT format('%I.%I.%I', n.nspname, c.relname, t.tgname), pg_get_triggerdef(t.oid)
+FROM pg_trigger t
+JOIN pg_class c ON c.oid = t.tgrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE NOT t.tgisinternal
+  AND ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_class", "c.oid")},
+	{"type", `
+SELECT
+  format('%I.%I', n.nspname, t.typname),
+  CASE t.typtype
+    WHEN 'e' THEN 'ENUM (' || (
+      SELECT string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder)
+      FROM pg_enum e WHERE e.enumtypid = t.oid
+    ) || ')'
+    WHEN 'd' THEN 'DOMAIN ' || format_type(t.typbasetype, t.typtypmod)
+      || CASE WHEN t.typnotnull THEN ' NOT NULL' ELSE '' END
+  END
+FROM pg_type t
+JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE t.typtype IN ('e', 'd')
+  AND ` + userSchemaFilter + ` AND ` + notExtensionMember("pg_type", "t.oid")},
+}
+
+// Schema describes user objects of a database.
+type Schema struct {
+	// Objects maps object keys, which are the object kind and the qualified
+	// object name separated by a space, e.g., "table public.users",
+	// to object definitions.
+	Objects map[string]string
+}
+
+// Describe describes schemas, tables, columns, views, sequences, indexes,
+// constraints, functions, triggers, enums and domains in user schemas,
+// skipping objects owned by extensions.
+func Describe(ctx context.Context, q Querier) (*Schema, error) {
+	s := Schema{Objects: make(map[string]string)}
+
+	for _, sq := range schemaQueries {
+		rows, err := q.Query(ctx, sq.sql)
+		if err != nil {
+			return nil, fmt.Errorf("describe %s objects: %w", sq.kind, err)
+		}
+
+		var name, def string
+
+		_, err = pgx.ForEachRow(rows, []any{&name, &def}, func() error {
+			s.Objects[sq.kind+" "+name] = def
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describe %s objects: %w", sq.kind, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// Fingerprint returns a hash of the schema, which changes whenever
+// any object is added, removed or changed.
+func (s *Schema) Fingerprint() string {
+	h := sha256.New()
+
+	for _, key := range slices.Sorted(maps.Keys(s.Objects)) {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(s.Objects[key]))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SchemaObject is a database object.
+type SchemaObject struct {
+	// Kind is the object kind, e.g., "table" or "index".
+	Kind string `json:"kind"`
+	// Name is the qualified object name.
+	Name string `json:"name"`
+	// Definition is the object definition.
+	Definition string `json:"definition"`
+}
+
+// ChangedSchemaObject is an object with different definitions.
+type ChangedSchemaObject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// SchemaDiff is the difference between two schemas.
+type SchemaDiff struct {
+	// Added, Removed and Changed objects are ordered by kind and name.
+	Added   []SchemaObject
+	Removed []SchemaObject
+	Changed []ChangedSchemaObject
+}
+
+// DiffSchemas reports objects added, removed and changed between
+// the a and b schemas.
+func DiffSchemas(a, b *Schema) *SchemaDiff {
+	var d SchemaDiff
+
+	for _, key := range slices.Sorted(maps.Keys(a.Objects)) {
+		kind, name, _ := strings.Cut(key, " ")
+
+		oldDef := a.Objects[key]
+
+		newDef, ok := b.Objects[key]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, SchemaObject{Kind: kind, Name: name, Definition: oldDef})
+		case newDef != oldDef:
+			d.Changed = append(d.Changed, ChangedSchemaObject{
+				Kind: kind,
+				Name: name,
+				Old:  oldDef,
+				New:  newDef,
+			})
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(b.Objects)) {
+		if _, ok := a.Objects[key]; !ok {
+			kind, name, _ := strings.Cut(key, " ")
+			d.Added = append(d.Added, SchemaObject{Kind: kind, Name: name, Definition: b.Objects[key]})
+		}
+	}
+
+	return &d
+}
+
+// Empty reports whether the schemas are equal.
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a human-readable representation of the difference.
+func (d *SchemaDiff) String() string {
+	var lines []string
+
+	for _, o := range d.Removed {
+		lines = append(lines, "- "+formatObject(o.Kind, o.Name, o.Definition))
+	}
+
+	for _, o := range d.Added {
+		lines = append(lines, "+ "+formatObject(o.Kind, o.Name, o.Definition))
+	}
+
+	for _, o := range d.Changed {
+		lines = append(lines,
+			"~ "+o.Kind+" "+o.Name+":",
+			"    - "+indent(o.Old),
+			"    + "+indent(o.New),
+		)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatObject(kind, name, def string) string {
+	if def == "" {
+		return kind + " " + name
+	}
+
+	return kind + " " + name + ": " + indent(def)
+}
+
+// indent indents continuation lines of multi-line definitions, such as
+// function bodies.
+func indent(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n      ")
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	t.Parallel()
+
+	a := &Schema{Objects: map[string]string{
+		"table public.users":         "",
+		"column public.users.id":     "integer NOT NULL",
+		"column public.users.name":   "text",
+		"index public.users_name_ix": "CREATE INDEX users_name_ix ON public.users (name)",
+	}}
+
+	t.Run("it reports added, removed and changed objects", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		b := &Schema{Objects: map[string]string{
+			"table public.users":       "",
+			"column public.users.id":   "bigint NOT NULL",
+			"column public.users.name": "text",
+			"column public.users.age":  "integer",
+		}}
+
+		// Act
+		d := DiffSchemas(a, b)
+
+		// Assert
+		assert.False(t, d.Empty())
+		assert.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+		assert.Equal(t, `- index public.users_name_ix: CREATE INDEX users_name_ix ON public.users (name)
++ column public.users.age: integer
+~ column public.users.id:
+    - integer NOT NULL
+    + bigint NOT NULL`, d.String())
+	})
+
+	t.Run("it reports no difference for equal schemas", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		b := &Schema{Objects: map[string]string{}}
+		for k, v := range a.Objects {
+			b.Objects[k] = v
+		}
+
+		// Act
+		d := DiffSchemas(a, b)
+
+		// Assert
+		assert.True(t, d.Empty())
+		assert.Equal(t, a.Fingerprint(), b.Fingerprint())
+	})
+}
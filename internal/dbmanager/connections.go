@@ -0,0 +1,31 @@
+package dbmanager
+
+import (
+	"context"
+	"fmt"
+)
+
+// AvailableConns returns the number of connections the server can still
+// accept from ordinary roles: max_connections less the reserved connection
+// slots and the client sessions currently connected, including those of
+// other processes.
+func (f *DBManager) AvailableConns(ctx context.Context) (int, error) {
+	mc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer mc.Release()
+
+	// reserved_connections is only available on PostgreSQL 16+.
+	var available int
+	if err := mc.QueryRow(ctx, `SELECT
+  current_setting('max_connections')::int
+  - current_setting('superuser_reserved_connections')::int
+  - coalesce(current_setting('reserved_connections', true)::int, 0)
+  - (SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'client backend')::int`,
+	).Scan(&available); err != nil {
+		return 0, fmt.Errorf("pgxephemeraltest: failed to compute available connections: %w", err)
+	}
+
+	return available, nil
+}
@@ -0,0 +1,236 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Database creation strategies supported by PostgreSQL 15+.
+const (
+	// StrategyWALLog copies databases block by block through the WAL, which
+	// is the default and fast for small templates.
+	StrategyWALLog = "WAL_LOG"
+
+	// StrategyFileCopy copies database files and checkpoints, which is faster
+	// for large templates.
+	StrategyFileCopy = "FILE_COPY"
+)
+
+// ErrInvalidCreateOptions is returned for create options that are unknown
+// or unsupported by the server.
+var ErrInvalidCreateOptions = errors.New("pgxephemeraltest: invalid database create options")
+
+// CreateOptions are options of CREATE DATABASE.
+//
+// Strategy and Tablespace apply to templates and the databases copied from
+// them. Encoding and locale options apply to templates created from scratch,
+// which are then created from template0, and are inherited by the databases
+// copied from them.
+type CreateOptions struct {
+	// Strategy is StrategyWALLog or StrategyFileCopy. It is ignored by
+	// servers older than PostgreSQL 15, which always copy files.
+	Strategy string
+
+	// Tablespace is the tablespace of databases, e.g., a tmpfs-backed one.
+	Tablespace string
+
+	// Encoding is the character set encoding, e.g., "UTF8".
+	Encoding string
+
+	// Locale sets both LC_COLLATE and LC_CTYPE, e.g., "C".
+	Locale string
+
+	// LCCollate is the collation order, overriding Locale.
+	LCCollate string
+
+	// LCCType is the character classification, overriding Locale.
+	LCCType string
+
+	// LocaleProvider is "libc", "icu" or, since PostgreSQL 17, "builtin".
+	// It requires PostgreSQL 15+.
+	LocaleProvider string
+
+	// ICULocale is the ICU locale, e.g., "und-x-icu". It requires
+	// PostgreSQL 15+.
+	ICULocale string
+}
+
+// createOption is an option of CREATE DATABASE requiring the server
+// version to be at least minVersion.
+type createOption struct {
+	name, value string
+	minVersion  int
+	// clone applies the option to databases copied from templates.
+	clone bool
+}
+
+func (o CreateOptions) options() []createOption {
+	return []createOption{
+		{"STRATEGY", o.Strategy, 150000, true},
+		{"TABLESPACE", o.Tablespace, 0, true},
+		{"ENCODING", o.Encoding, 0, false},
+		{"LOCALE", o.Locale, 0, false},
+		{"LC_COLLATE", o.LCCollate, 0, false},
+		{"LC_CTYPE", o.LCCType, 0, false},
+		{"LOCALE_PROVIDER", o.LocaleProvider, 150000, false},
+		{"ICU_LOCALE", o.ICULocale, 150000, false},
+	}
+}
+
+// Identity returns the string identifying templates created with
+// the options, which is empty for default options. Strategy is not part of
+// the identity, since it does not affect the created database. Values the
+// server treats case-insensitively are normalized, so that, e.g., "utf8"
+// and "UTF8" encodings identify the same templates.
+func (o CreateOptions) Identity() string {
+	var parts []string
+
+	for _, opt := range o.normalized().options() {
+		if opt.value != "" && opt.name != "STRATEGY" {
+			parts = append(parts, opt.name+"="+opt.value)
+		}
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// normalized returns the options with the values the server treats
+// case-insensitively in their canonical case.
+func (o CreateOptions) normalized() CreateOptions {
+	o.Strategy = strings.ToUpper(o.Strategy)
+	o.Encoding = strings.ToUpper(o.Encoding)
+	o.LocaleProvider = strings.ToLower(o.LocaleProvider)
+
+	return o
+}
+
+// validate checks the options against the server version.
+func (o CreateOptions) validate(version int) error {
+	switch strings.ToUpper(o.Strategy) {
+	case "", StrategyWALLog, StrategyFileCopy:
+	default:
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidCreateOptions, o.Strategy)
+	}
+
+	switch strings.ToLower(o.LocaleProvider) {
+	case "", "libc", "icu", "builtin":
+	default:
+		return fmt.Errorf("%w: unknown locale provider %q", ErrInvalidCreateOptions, o.LocaleProvider)
+	}
+
+	for _, opt := range o.options() {
+		if opt.value != "" && opt.name != "STRATEGY" && version < opt.minVersion {
+			return fmt.Errorf(
+				"%w: %s requires PostgreSQL %d+",
+				ErrInvalidCreateOptions,
+				opt.name,
+				opt.minVersion/10000,
+			)
+		}
+	}
+
+	return nil
+}
+
+// fromScratch reports whether templates created from scratch must be
+// copied from template0, as template1 may have another encoding or locale.
+func (o CreateOptions) fromScratch() bool {
+	for _, opt := range o.options() {
+		if opt.value != "" && !opt.clone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createClause returns the options clause of CREATE DATABASE, limited to
+// options applying to copies of templates if clone is set. Clauses are
+// built once and cached, as the options do not change.
+func (f *DBManager) createClause(ctx context.Context, mc *pgx.Conn, clone bool) (string, error) {
+	if f.create == (CreateOptions{}) { //nolint:exhaustruct // zero value.
+		return "", nil
+	}
+
+	if clause, ok := f.clauses.Load(clone); ok {
+		return clause.(string), nil //nolint:forcetypeassert // only strings are stored.
+	}
+
+	clause, err := f.buildCreateClause(ctx, mc, clone)
+	if err != nil {
+		return "", err
+	}
+
+	f.clauses.Store(clone, clause)
+
+	return clause, nil
+}
+
+func (f *DBManager) buildCreateClause(ctx context.Context, mc *pgx.Conn, clone bool) (string, error) {
+	version, err := f.serverVersion(ctx, mc)
+	if err != nil {
+		return "", err
+	}
+
+	if err := f.create.validate(version); err != nil {
+		return "", err
+	}
+
+	var names, values []string
+
+	for _, opt := range f.create.options() {
+		if opt.value == "" || (clone && !opt.clone) || version < opt.minVersion {
+			continue
+		}
+
+		names = append(names, opt.name)
+		values = append(values, opt.value)
+	}
+
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	// The values are quoted server-side to respect the server settings
+	// affecting string literals.
+	rows, err := mc.Query(
+		ctx,
+		"SELECT quote_literal(v) FROM unnest($1::text[]) WITH ORDINALITY AS t(v, i) ORDER BY i",
+		values,
+	)
+	if err != nil {
+		return "", fmt.Errorf("pgxephemeraltest: failed to quote create options: %w", err)
+	}
+
+	quoted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", fmt.Errorf("pgxephemeraltest: failed to quote create options: %w", err)
+	}
+
+	clause := make([]string, len(names))
+	for i, name := range names {
+		clause[i] = name + " " + quoted[i]
+	}
+
+	return " " + strings.Join(clause, " "), nil
+}
+
+// serverVersion returns the server version number, e.g., 170002.
+func (f *DBManager) serverVersion(ctx context.Context, mc *pgx.Conn) (int, error) {
+	if v := f.version.Load(); v != 0 {
+		return int(v), nil
+	}
+
+	var version int32
+	if err := mc.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return 0, fmt.Errorf("pgxephemeraltest: failed to detect server version: %w", err)
+	}
+
+	f.version.Store(version)
+
+	return int(version), nil
+}
@@ -6,11 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"maps"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -58,33 +63,153 @@ type DBInfo struct {
 // provided migrator.
 type DBManager struct {
 	config *pgxpool.Config
+	// settings are configuration parameters set on each created database.
+	settings map[string]string
+	// create are options of CREATE DATABASE.
+	create CreateOptions
+	// version caches the server version number.
+	version atomic.Int32
+	// clauses caches options clauses of CREATE DATABASE by the clone flag.
+	clauses sync.Map
+	// touched holds times the usage of templates was last recorded.
+	touched sync.Map
+
+	// maintenanceConns is the maximum size of the maintenance pool.
+	maintenanceConns int32
+
+	mu     sync.Mutex
+	pool   *pgxpool.Pool // nil until first used
+	closed bool
+}
+
+// DefaultMaintenanceConns is the default maximum number of maintenance
+// connections of DBManager.
+const DefaultMaintenanceConns = 4
+
+// maintenanceIdleTime is the time after which idle maintenance connections
+// are closed.
+const maintenanceIdleTime = 30 * time.Second
+
+// ErrClosed is returned when using a closed DBManager.
+var ErrClosed = errors.New("pgxephemeraltest: database manager is closed")
+
+// Option configures DBManager.
+type Option func(*DBManager)
+
+// WithDatabaseSettings sets configuration parameters on each database
+// created by CreateDB with ALTER DATABASE ... SET, so they apply to all
+// sessions connecting to the database.
+func WithDatabaseSettings(settings map[string]string) Option {
+	return func(m *DBManager) { m.settings = maps.Clone(settings) }
+}
+
+// WithCreateOptions sets options of CREATE DATABASE used for templates and
+// databases created by the manager.
+func WithCreateOptions(options CreateOptions) Option {
+	return func(m *DBManager) { m.create = options }
+}
+
+// WithMaintenanceConns sets the maximum number of maintenance connections,
+// which also caps the number of concurrent CREATE DATABASE statements
+// issued by the manager.
+func WithMaintenanceConns(n int32) Option {
+	return func(m *DBManager) { m.maintenanceConns = max(n, 1) }
 }
 
 // New creates a new DBManager instance.
 //
 // It copies the provided database configuration for later use by the manager.
-// Database and template initialization is performed by Init.
+// Database and template initialization is performed by Init. Maintenance
+// connections are opened on demand and are closed by Close.
 func New(
 	_ context.Context,
 	config *pgxpool.Config,
+	opts ...Option,
 ) (*DBManager, error) {
-	m := DBManager{config: config.Copy()}
+	//nolint:exhaustruct // version, touched and the pool start empty.
+	m := DBManager{
+		config:           config.Copy(),
+		settings:         nil,
+		create:           CreateOptions{},
+		maintenanceConns: DefaultMaintenanceConns,
+	}
+	for _, opt := range opts {
+		opt(&m)
+	}
 
 	return &m, nil
 }
 
 // Init creates a new template database owned by the supplied user.
-func (f *DBManager) Init(ctx context.Context, migrator Migrator, tpl string) (err error) {
-	mc, err := f.newMaintenanceConn(ctx)
+func (f *DBManager) Init(ctx context.Context, migrator Migrator, tpl string) error {
+	return f.InitFrom(ctx, migrator, "", tpl)
+}
+
+// InitFrom is like Init, but the template is created as a copy of the base
+// template with the migrator applied on top of it. If base is empty, the
+// template is created from scratch.
+//
+// It allows building a chain of templates, where each template extends
+// the previous one, without re-applying migrations of the base template.
+func (f *DBManager) InitFrom(ctx context.Context, migrator Migrator, base, tpl string) error {
+	return f.withTemplateLock(ctx, tpl, func(mc *pgx.Conn) error {
+		if err := f.mkTemplate(ctx, mc, migrator, f.config.ConnConfig.User, base, tpl); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to create database template: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// RebuildFrom recreates the tpl template as InitFrom does, if the template
+// fingerprint is still the stale one.
+//
+// The check is made under the template lock, so when several processes
+// find the same template stale, it is rebuilt only once. Sessions of other
+// processes cloning the template are not terminated: the drop is retried
+// until they finish, and fails if they do not finish in time.
+func (f *DBManager) RebuildFrom(ctx context.Context, migrator Migrator, base, tpl, stale string) error {
+	return f.withTemplateLock(ctx, tpl, func(mc *pgx.Conn) error {
+		md, err := readMetadata(ctx, mc, tpl)
+		if err != nil {
+			return err
+		}
+
+		if md.Fingerprint != stale {
+			return nil // Rebuilt by someone else.
+		}
+
+		if err := retryTransient(ctx, "drop stale template "+tpl, func() error {
+			return f.dropDBConn(ctx, mc, tpl, false)
+		}); err != nil {
+			if restoreErr := restoreTemplate(ctx, mc, tpl); restoreErr != nil {
+				err = errors.Join(err, restoreErr)
+			}
+
+			return fmt.Errorf("pgxephemeraltest: failed to drop stale database template: %w", err)
+		}
+
+		if err := f.mkTemplate(ctx, mc, migrator, f.config.ConnConfig.User, base, tpl); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to create database template: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// withTemplateLock runs fn holding the lock of the tpl template. fn is
+// passed the maintenance connection holding the lock.
+func (f *DBManager) withTemplateLock(ctx context.Context, tpl string, fn func(*pgx.Conn) error) (err error) {
+	mc, err := f.acquireMaintenanceConn(ctx)
 	if err != nil {
 		return fmt.Errorf("pgxephemeraltest: failed to connect to database: %w", err)
 	}
-	defer mc.Close(ctx)
+	defer mc.Release()
 
 	// Linearize the creation of database template across multiple processes, since
 	// it is a shared resource and can cause conflicts, when trying to initialize
 	// it simultaneously.
-	releaseLock, err := acquireLock(ctx, mc, tpl)
+	releaseLock, err := acquireLock(ctx, mc.Conn(), tpl)
 	if err != nil {
 		return fmt.Errorf("pgxephemeraltest: failed to take lock: %w", err)
 	}
@@ -103,23 +228,47 @@ func (f *DBManager) Init(ctx context.Context, migrator Migrator, tpl string) (er
 		err = errors.Join(err, releaseErr)
 	}()
 
-	if err := f.mkTemplate(ctx, migrator, f.config.ConnConfig.User, tpl); err != nil {
-		return fmt.Errorf("pgxephemeraltest: failed to create database template: %w", err)
-	}
-
-	return err
+	return fn(mc.Conn())
 }
 
 // CreateDB creates a new db ephemeral database and returns the database name.
+//
+// Transient failures, e.g., when other sessions are connected to the template
+// or the server is out of connection slots, are retried with backoff. Sessions
+// holding the template are logged, see ContextWithLogf.
 func (f *DBManager) CreateDB(ctx context.Context, tpl string, db string) (string, error) {
-	mc, err := f.newMaintenanceConn(ctx)
+	var created string
+
+	err := retryTransient(ctx, "copy database template "+tpl, func() error {
+		var err error
+
+		created, err = f.createDB(ctx, tpl, db)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return created, nil
+}
+
+func (f *DBManager) createDB(ctx context.Context, tpl string, db string) (string, error) {
+	pc, err := f.acquireMaintenanceConn(ctx)
 	if err != nil {
 		return "", fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
 	}
-	defer mc.Close(ctx)
+	defer pc.Release()
+
+	mc := pc.Conn()
 
 	db = DatabasePrefix + db
 
+	clause, err := f.createClause(ctx, mc, true)
+	if err != nil {
+		return "", err
+	}
+
 	_, err = mc.Exec(ctx, strings.Join([]string{
 		"CREATE DATABASE",
 		pgx.Identifier{db}.Sanitize(),
@@ -127,25 +276,66 @@ func (f *DBManager) CreateDB(ctx context.Context, tpl string, db string) (string
 		pgx.Identifier{tpl}.Sanitize(),
 		"OWNER",
 		pgx.Identifier{f.config.ConnConfig.User}.Sanitize(),
-	}, " "))
+	}, " ")+clause)
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == objectInUse {
+			logHolders(ctx, mc, tpl)
+		}
+
 		return "", fmt.Errorf("pgxephemeraltest: failed to copy database template: %w", err)
 	}
 
+	if err := f.applySettings(ctx, mc, db); err != nil {
+		if _, dropErr := mc.Exec(
+			context.WithoutCancel(ctx),
+			"DROP DATABASE IF EXISTS "+pgx.Identifier{db}.Sanitize(),
+		); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+
+		return "", err
+	}
+
+	f.touch(ctx, mc, tpl)
+
 	return db, nil
 }
 
 // DropDB drops the db ephemeral database after testing.
+//
+// Sessions still connected to the database, e.g., leaked by tests, are
+// terminated and reported, see ContextWithLogf.
+//
+// DropDB keeps working after Close, as cleanups of tests may run after
+// the manager is closed, e.g., by TestMain. The database is then dropped
+// using a dedicated connection.
 func (f *DBManager) DropDB(ctx context.Context, db string) error {
-	if !strings.HasPrefix(db, DatabasePrefix) && !strings.HasPrefix(db, TemplatePrefix) {
-		return fmt.Errorf("pgxephemeraltest: refusing to drop unmanaged database %q", db)
+	pc, err := f.acquireMaintenanceConn(ctx)
+	if errors.Is(err, ErrClosed) {
+		conn, err := f.newConn(ctx, "")
+		if err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to connect to maintenance database: %w", err)
+		}
+		defer conn.Close(context.WithoutCancel(ctx))
+
+		return f.dropDBConn(ctx, conn, db, true)
 	}
 
-	mc, err := f.newMaintenanceConn(ctx)
 	if err != nil {
 		return fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
 	}
-	defer mc.Close(ctx)
+	defer pc.Release()
+
+	return f.dropDBConn(ctx, pc.Conn(), db, true)
+}
+
+// dropDBConn drops the db database using the mc maintenance connection,
+// terminating sessions connected to it if force is set.
+func (f *DBManager) dropDBConn(ctx context.Context, mc *pgx.Conn, db string, force bool) error {
+	if !strings.HasPrefix(db, DatabasePrefix) && !strings.HasPrefix(db, TemplatePrefix) {
+		return fmt.Errorf("pgxephemeraltest: refusing to drop unmanaged database %q", db)
+	}
 
 	if _, err := mc.Exec(
 		ctx,
@@ -155,9 +345,18 @@ func (f *DBManager) DropDB(ctx context.Context, db string) error {
 		return fmt.Errorf("pgxephemeraltest: failed to unset template flag: %w", err)
 	}
 
+	var clause string
+
+	if force {
+		var err error
+		if clause, err = f.disconnect(ctx, mc, []string{db}); err != nil {
+			return err
+		}
+	}
+
 	if _, err := mc.Exec(
 		ctx,
-		strings.Join([]string{"DROP DATABASE", pgx.Identifier{db}.Sanitize()}, " "),
+		strings.Join([]string{"DROP DATABASE", pgx.Identifier{db}.Sanitize()}, " ")+clause,
 	); err != nil {
 		return fmt.Errorf("pgxephemeraltest: failed to drop database %s: %w", db, err)
 	}
@@ -200,11 +399,13 @@ func (f *DBManager) DropDBs(ctx context.Context, dbs []string) error {
 		}
 	}
 
-	mc, err := f.newMaintenanceConn(ctx)
+	pc, err := f.acquireMaintenanceConn(ctx)
 	if err != nil {
 		return fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
 	}
-	defer mc.Close(ctx)
+	defer pc.Release()
+
+	mc := pc.Conn()
 
 	if len(templates) > 0 {
 		for chunk := range slices.Chunk(templates, 256) {
@@ -219,9 +420,14 @@ func (f *DBManager) DropDBs(ctx context.Context, dbs []string) error {
 	}
 
 	for chunk := range slices.Chunk(dbs, 256) {
+		clause, err := f.disconnect(ctx, mc, chunk)
+		if err != nil {
+			return err
+		}
+
 		var b pgx.Batch
 		for _, dbName := range chunk {
-			b.Queue(strings.Join([]string{"DROP DATABASE", pgx.Identifier{dbName}.Sanitize()}, " "))
+			b.Queue("DROP DATABASE " + pgx.Identifier{dbName}.Sanitize() + clause)
 		}
 
 		result := mc.SendBatch(ctx, &b)
@@ -234,11 +440,11 @@ func (f *DBManager) DropDBs(ctx context.Context, dbs []string) error {
 }
 
 func (f *DBManager) ListDBs(ctx context.Context) ([]DBInfo, error) {
-	mc, err := f.newMaintenanceConn(ctx)
+	mc, err := f.acquireMaintenanceConn(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
 	}
-	defer mc.Close(ctx)
+	defer mc.Release()
 
 	rows, err := mc.Query(
 		ctx,
@@ -288,12 +494,19 @@ func (f *DBManager) newConn(ctx context.Context, db string) (*pgx.Conn, error) {
 	return conn, nil
 }
 
-// newMaintenanceConn creates a new maintenance connection.
+// acquireMaintenanceConn acquires a connection from the maintenance pool,
+// which is created on the first call.
 //
 // Maintenance connection is used to manage ephemeral databases lifecycle.
-// The caller is responsible for closing the connection when done.
-func (f *DBManager) newMaintenanceConn(ctx context.Context) (*pgx.Conn, error) {
-	conn, err := f.newConn(ctx, "")
+// The caller is responsible for releasing the connection when done, and
+// must not acquire another one while holding it, as the pool is bounded.
+func (f *DBManager) acquireMaintenanceConn(ctx context.Context) (*pgxpool.Conn, error) {
+	pool, err := f.maintenancePool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Acquire(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to acquire maintenance connection: %w", err)
 	}
@@ -301,21 +514,71 @@ func (f *DBManager) newMaintenanceConn(ctx context.Context) (*pgx.Conn, error) {
 	return conn, nil
 }
 
-// mkTemplate creates a new database template with migrations applied.
-// If the template exists, it will skip migration.
+func (f *DBManager) maintenancePool(ctx context.Context) (*pgxpool.Pool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrClosed
+	}
+
+	if f.pool != nil {
+		return f.pool, nil
+	}
+
+	config, err := pgxpool.ParseConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create maintenance pool: %w", err)
+	}
+
+	// Only the connection settings are used, as pool hooks of the config
+	// are meant for ephemeral databases. Maintenance is bursty, e.g., on
+	// factory creation, so idle connections are not kept around.
+	config.ConnConfig = f.config.ConnConfig.Copy()
+	config.MaxConns = f.maintenanceConns
+	config.MinConns = 0
+	config.MinIdleConns = 0
+	config.MaxConnIdleTime = maintenanceIdleTime
+	config.HealthCheckPeriod = maintenanceIdleTime
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create maintenance pool: %w", err)
+	}
+
+	f.pool = pool
+
+	return pool, nil
+}
+
+// Close closes the maintenance connections. The manager cannot be used
+// afterwards, except for DropDB.
+func (f *DBManager) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pool != nil {
+		f.pool.Close()
+	}
+
+	f.closed = true
+}
+
+// mkTemplate creates a new database template with migrations applied,
+// copying the base template if it is not empty. If the template exists,
+// it will skip migration.
 //
 // Generally, mkTemplate is expected to be called only once at the factory
 // initialization.
 //
-// mkTemplate is not thread-safe; attempting to run it concurrently will result in
-// connection lock (pgx busy conn).
-func (f *DBManager) mkTemplate(ctx context.Context, migrator Migrator, user, template string) error {
-	mc, err := f.newMaintenanceConn(ctx)
-	if err != nil {
-		return fmt.Errorf("pgxephemeraltest: failed to get maintenance connection: %w", err)
-	}
-	defer mc.Close(ctx)
-
+// mkTemplate is not thread-safe; it is run with the mc maintenance connection
+// holding the template lock.
+func (f *DBManager) mkTemplate(
+	ctx context.Context,
+	mc *pgx.Conn,
+	migrator Migrator,
+	user, base, template string,
+) error {
 	// It is safe to check only if the database is marked as a template, since marking
 	// it as a template is the last step in the process.
 	var doesTemplateExists bool
@@ -326,6 +589,8 @@ func (f *DBManager) mkTemplate(ctx context.Context, migrator Migrator, user, tem
 	}
 
 	if doesTemplateExists {
+		f.touch(ctx, mc, template)
+
 		return nil // Template already exists
 	}
 
@@ -338,12 +603,25 @@ func (f *DBManager) mkTemplate(ctx context.Context, migrator Migrator, user, tem
 		return fmt.Errorf("pgxephemeraltest: failed to drop existing database template: %w", err)
 	}
 
-	if _, err := mc.Exec(ctx, strings.Join([]string{
-		"CREATE DATABASE",
-		pgx.Identifier{template}.Sanitize(),
-		"OWNER",
-		pgx.Identifier{user}.Sanitize(),
-	}, " ")); err != nil {
+	// Encoding and locale of copies must match the base template, so they
+	// only apply to templates created from scratch, copying template0.
+	clause, err := f.createClause(ctx, mc, base != "")
+	if err != nil {
+		return err
+	}
+
+	if base == "" && f.create.fromScratch() {
+		base = "template0"
+	}
+
+	stmt := []string{"CREATE DATABASE", pgx.Identifier{template}.Sanitize()}
+	if base != "" {
+		stmt = append(stmt, "TEMPLATE", pgx.Identifier{base}.Sanitize())
+	}
+
+	stmt = append(stmt, "OWNER", pgx.Identifier{user}.Sanitize())
+
+	if _, err := mc.Exec(ctx, strings.Join(stmt, " ")+clause); err != nil {
 		return fmt.Errorf("pgxephemeraltest: failed to create database template: %w", err)
 	}
 
@@ -358,6 +636,21 @@ func (f *DBManager) mkTemplate(ctx context.Context, migrator Migrator, user, tem
 		return fmt.Errorf("pgxephemeraltest: failed to run migrations: %w", err)
 	}
 
+	fp, err := fingerprint(ctx, tc)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := writeMetadata(ctx, mc, template, TemplateMetadata{
+		Fingerprint: fp,
+		Lineage:     Lineage(f.config.ConnConfig, migrator),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+	}); err != nil {
+		return err
+	}
+
 	if _, err := mc.Exec(
 		ctx,
 		"UPDATE pg_database SET datistemplate = true WHERE datname = $1",
@@ -383,6 +676,10 @@ func acquireLock(ctx context.Context, conn *pgx.Conn, name string) (func(ctx con
 
 	return func(ctx context.Context) error {
 		if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock($1::BIGINT)", lockNum); err != nil {
+			// Closing the session releases the lock, and prevents the pool
+			// from reusing the connection holding it.
+			_ = conn.Close(ctx)
+
 			return fmt.Errorf("pgxephemeraltest: failed to release lock: %w", err)
 		}
 
@@ -391,12 +688,21 @@ func acquireLock(ctx context.Context, conn *pgx.Conn, name string) (func(ctx con
 }
 
 // TemplateName returns a unique template name for the given migration set.
-func TemplateName(config *pgx.ConnConfig, m Migrator) string {
+// Non-empty identity strings, e.g., CreateOptions.Identity, distinguish
+// templates of the same migration set created differently.
+func TemplateName(config *pgx.ConnConfig, m Migrator, identity ...string) string {
 	h := fnv.New64()
 	h.Write([]byte(config.User))
 	h.Write([]byte(config.Password))
 	h.Write([]byte(m.Hash()))
 
+	for _, id := range identity {
+		if id != "" {
+			h.Write([]byte{0})
+			h.Write([]byte(id))
+		}
+	}
+
 	template := TemplatePrefix + strconv.FormatUint(h.Sum64(), 16)
 
 	return template
@@ -0,0 +1,99 @@
+package dbmanager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// forceDropVersion is the first server version supporting
+// DROP DATABASE ... WITH (FORCE).
+const forceDropVersion = 130000
+
+// disconnect reports sessions connected to the dbs databases, which are
+// about to be dropped, and returns the DROP DATABASE clause terminating
+// them. Servers older than PostgreSQL 13 do not support the clause, so
+// the sessions are terminated with pg_terminate_backend instead, waiting
+// for them to exit.
+func (f *DBManager) disconnect(ctx context.Context, mc *pgx.Conn, dbs []string) (string, error) {
+	version, err := f.serverVersion(ctx, mc)
+	if err != nil {
+		return "", err
+	}
+
+	terminate := version < forceDropVersion
+
+	// pg_terminate_backend is called in the select list, so it is only
+	// evaluated for rows passing the filter.
+	rows, err := mc.Query(ctx, `SELECT
+  datname,
+  pid,
+  application_name,
+  CASE WHEN $2::bool THEN pg_terminate_backend(pid) ELSE false END
+FROM pg_stat_activity
+WHERE datname = ANY($1) AND pid <> pg_backend_pid()`, dbs, terminate)
+	if err != nil {
+		return "", fmt.Errorf("pgxephemeraltest: failed to terminate database sessions: %w", err)
+	}
+
+	var (
+		db, app    string
+		pid        int32
+		terminated bool
+		sessions   int
+	)
+
+	_, err = pgx.ForEachRow(rows, []any{&db, &pid, &app, &terminated}, func() error {
+		sessions++
+
+		logf(ctx, "pgxephemeraltest: terminating session connected to database %s (backend %d, application %q)",
+			db, pid, app)
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("pgxephemeraltest: failed to terminate database sessions: %w", err)
+	}
+
+	if !terminate {
+		return " WITH (FORCE)", nil
+	}
+
+	if sessions > 0 {
+		if err := waitDisconnected(ctx, mc, dbs); err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// waitDisconnected waits for sessions connected to the dbs databases to
+// exit, as pg_terminate_backend only signals them, for up to retryTimeout.
+// Sessions still connected afterwards make DROP DATABASE fail.
+func waitDisconnected(ctx context.Context, mc *pgx.Conn, dbs []string) error {
+	deadline := time.Now().Add(retryTimeout)
+
+	for {
+		var connected bool
+		if err := mc.QueryRow(ctx, `SELECT exists(
+  SELECT 1 FROM pg_stat_activity WHERE datname = ANY($1) AND pid <> pg_backend_pid()
+)`, dbs).Scan(&connected); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to check database sessions: %w", err)
+		}
+
+		if !connected || time.Now().After(deadline) {
+			return nil
+		}
+
+		timer := time.NewTimer(retryBaseDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("pgxephemeraltest: failed to wait for terminated sessions: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
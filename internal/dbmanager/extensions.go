@@ -0,0 +1,69 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrExtensionUnavailable is returned when required extensions are not
+// available on the server.
+var ErrExtensionUnavailable = errors.New("pgxephemeraltest: extension is not available")
+
+// ExtensionVersions returns the default versions of the named extensions
+// available on the server.
+//
+// If any of the extensions is not available, e.g., the contrib package is
+// not installed, it returns an error wrapping ErrExtensionUnavailable that
+// lists all missing extensions.
+func (f *DBManager) ExtensionVersions(ctx context.Context, names []string) (map[string]string, error) {
+	mc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer mc.Release()
+
+	rows, err := mc.Query(
+		ctx,
+		"SELECT name, default_version FROM pg_available_extensions WHERE name = ANY($1)",
+		names,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to list available extensions: %w", err)
+	}
+
+	versions := make(map[string]string, len(names))
+
+	var name, version string
+
+	_, err = pgx.ForEachRow(rows, []any{&name, &version}, func() error {
+		versions[name] = version
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to list available extensions: %w", err)
+	}
+
+	var missing []string
+
+	for _, name := range names {
+		if _, ok := versions[name]; !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf(
+			"%w on server %s: %s (install the package providing it, e.g., postgresql-contrib)",
+			ErrExtensionUnavailable,
+			f.config.ConnConfig.Host,
+			strings.Join(missing, ", "),
+		)
+	}
+
+	return versions, nil
+}
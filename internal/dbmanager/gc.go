@@ -0,0 +1,151 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// objectInUse is the SQLSTATE of errors dropping databases other sessions
+// are connected to.
+const objectInUse = "55006"
+
+// ErrInvalidGCPolicy is returned when a GC policy would collect every
+// template.
+var ErrInvalidGCPolicy = errors.New("pgxephemeraltest: GC policy must set KeepLatest or TTL")
+
+// GCPolicy defines which templates are kept by CollectTemplates. A template
+// is kept if it satisfies any of the conditions.
+type GCPolicy struct {
+	// KeepLatest is the number of the most recently used templates to keep
+	// for each lineage. Templates without a lineage are not collected by
+	// KeepLatest, see Lineage.
+	KeepLatest int
+
+	// TTL keeps templates used within the duration.
+	TTL time.Duration
+}
+
+// CollectTemplates drops templates not kept by the policy and returns their
+// names. The keep templates are never dropped.
+//
+// Templates are ranked by the last usage time recorded on each Init and
+// CreateDB call. Templates without usage metadata, e.g., created by older
+// versions, are ranked as never used. Templates in use, e.g., being cloned,
+// are skipped.
+func (f *DBManager) CollectTemplates(ctx context.Context, policy GCPolicy, keep ...string) ([]string, error) {
+	if policy.KeepLatest <= 0 && policy.TTL <= 0 {
+		return nil, ErrInvalidGCPolicy
+	}
+
+	templates, err := f.ListTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	ranks := make(map[string]int) // number of more recent templates by lineage
+
+	slices.SortStableFunc(templates, func(a, b TemplateInfo) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+
+	var dropped []string
+
+	for _, tpl := range templates {
+		switch {
+		case slices.Contains(keep, tpl.Name),
+			policy.TTL > 0 && now.Sub(tpl.LastUsedAt) < policy.TTL:
+			continue
+		case tpl.Lineage == "":
+			// Templates of unknown lineage may be the latest ones of other
+			// projects, so they are only collected by TTL.
+			if policy.TTL <= 0 {
+				continue
+			}
+		default:
+			rank := ranks[tpl.Lineage]
+			ranks[tpl.Lineage]++
+
+			if rank < policy.KeepLatest {
+				continue
+			}
+		}
+
+		ok, err := f.dropUnused(ctx, tpl)
+		if err != nil {
+			return dropped, err
+		}
+
+		if ok {
+			dropped = append(dropped, tpl.Name)
+		}
+	}
+
+	return dropped, nil
+}
+
+// dropUnused drops the template, unless it was used since it was listed
+// or it is in use. It reports whether the template was dropped.
+func (f *DBManager) dropUnused(ctx context.Context, tpl TemplateInfo) (bool, error) {
+	var dropped bool
+
+	err := f.withTemplateLock(ctx, tpl.Name, func(mc *pgx.Conn) error {
+		md, err := readMetadata(ctx, mc, tpl.Name)
+		if err != nil {
+			return err
+		}
+
+		if !md.LastUsedAt.Equal(tpl.LastUsedAt) {
+			return nil // Used in the meantime.
+		}
+
+		var inUse bool
+		if err := mc.QueryRow(
+			ctx,
+			"SELECT exists(SELECT 1 FROM pg_stat_activity WHERE datname = $1)",
+			tpl.Name,
+		).Scan(&inUse); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to check template activity: %w", err)
+		}
+
+		if inUse {
+			return nil
+		}
+
+		if err := f.dropDBConn(ctx, mc, tpl.Name, false); err != nil {
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) || pgErr.Code != objectInUse {
+				return err
+			}
+
+			// Someone connected in the meantime, restore the template.
+			return restoreTemplate(ctx, mc, tpl.Name)
+		}
+
+		dropped = true
+
+		return nil
+	})
+
+	return dropped, err
+}
+
+// restoreTemplate marks the tpl database as a template again after
+// a failed drop, which unsets the flag first.
+func restoreTemplate(ctx context.Context, mc *pgx.Conn, tpl string) error {
+	if _, err := mc.Exec(
+		ctx,
+		"UPDATE pg_database SET datistemplate = true WHERE datname = $1",
+		tpl,
+	); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to restore template flag: %w", err)
+	}
+
+	return nil
+}
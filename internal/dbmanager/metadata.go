@@ -0,0 +1,327 @@
+package dbmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+)
+
+// TemplateMetadata is metadata of a template database, stored as JSON
+// in the database comment.
+type TemplateMetadata struct {
+	// Fingerprint is the schema fingerprint of the template computed after
+	// running the migrator.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Lineage identifies the migrator the template is built by, see Lineage.
+	// It is empty for migrators without a lineage.
+	Lineage string `json:"lineage,omitempty"`
+
+	// CreatedAt is the time the template was created.
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+
+	// LastUsedAt is the time the template was last initialized or cloned,
+	// recorded at most once per usageInterval by each DBManager.
+	LastUsedAt time.Time `json:"lastUsedAt,omitzero"`
+}
+
+// usageInterval is the minimum interval between updates of LastUsedAt
+// of a template made by a DBManager.
+const usageInterval = time.Minute
+
+// Lineager is implemented by migrators reporting their lineage.
+type Lineager interface {
+	// Lineage returns the name identifying successive versions of
+	// the migrator, which stays the same when migrations change.
+	Lineage() string
+}
+
+// Lineage returns the lineage of templates built by the migrator.
+//
+// Templates of the same lineage are successive versions built by the same
+// migrator, so all but the latest of them are likely unused. The lineage is
+// the one reported by the migrator if it implements Lineager, qualified by
+// the user owning the template.
+//
+// Migrators not reporting a lineage have none, since their Go type is shared
+// by unrelated projects, e.g., all migrators built by the same package, and
+// grouping their templates would make one project collect the templates of
+// another.
+func Lineage(config *pgx.ConnConfig, m Migrator) string {
+	l, ok := m.(Lineager)
+	if !ok || l.Lineage() == "" {
+		return ""
+	}
+
+	return config.User + "/" + l.Lineage()
+}
+
+// TemplateMetadata returns metadata of the tpl template. Metadata of
+// templates created without it, or with a comment set by someone else,
+// is empty.
+func (f *DBManager) TemplateMetadata(ctx context.Context, tpl string) (TemplateMetadata, error) {
+	var md TemplateMetadata
+
+	pc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return md, fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer pc.Release()
+
+	mc := pc.Conn()
+
+	return readMetadata(ctx, mc, tpl)
+}
+
+// Fingerprint returns the schema fingerprint of the tpl template.
+//
+// The fingerprint is computed when the template is created. For templates
+// created before fingerprints were recorded, it is computed and stored on
+// the first call. Connecting to the template would make concurrent clones
+// of it fail, so a scratch copy of the template is described instead.
+func (f *DBManager) Fingerprint(ctx context.Context, tpl string) (string, error) {
+	md, err := f.TemplateMetadata(ctx, tpl)
+	if err != nil {
+		return "", err
+	}
+
+	if md.Fingerprint != "" {
+		return md.Fingerprint, nil
+	}
+
+	s, err := f.DescribeMigration(ctx, nil, tpl)
+	if err != nil {
+		return "", err
+	}
+
+	fp := s.Fingerprint()
+
+	pc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return "", fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer pc.Release()
+
+	mc := pc.Conn()
+
+	// Metadata is read again, as the template may have been used meanwhile.
+	if md, err = readMetadata(ctx, mc, tpl); err != nil {
+		return "", err
+	}
+
+	md.Fingerprint = fp
+	if err := writeMetadata(ctx, mc, tpl, md); err != nil {
+		return "", err
+	}
+
+	return fp, nil
+}
+
+// TemplateInfo holds information about a template database.
+type TemplateInfo struct {
+	Name string `json:"name"`
+	TemplateMetadata
+}
+
+// ListTemplates lists templates with their metadata.
+func (f *DBManager) ListTemplates(ctx context.Context) ([]TemplateInfo, error) {
+	mc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer mc.Release()
+
+	rows, err := mc.Query(
+		ctx,
+		`SELECT datname, shobj_description(oid, 'pg_database')
+FROM pg_database
+WHERE datname LIKE $1 AND datistemplate
+ORDER BY oid`,
+		TemplatePrefix+"%",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to list templates: %w", err)
+	}
+
+	var (
+		templates []TemplateInfo
+		name      string
+		comment   *string
+	)
+
+	_, err = pgx.ForEachRow(rows, []any{&name, &comment}, func() error {
+		templates = append(templates, TemplateInfo{Name: name, TemplateMetadata: parseMetadata(comment)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to list templates: %w", err)
+	}
+
+	return templates, nil
+}
+
+// touch records the usage of the tpl template, unless it was recorded
+// by the manager less than usageInterval ago.
+//
+// Usage tracking is best effort: concurrent updates of the comment may
+// fail, and the failure is ignored, as another process recorded the usage.
+func (f *DBManager) touch(ctx context.Context, mc *pgx.Conn, tpl string) {
+	if !strings.HasPrefix(tpl, TemplatePrefix) {
+		return // Not a managed template, e.g., template1.
+	}
+
+	now := time.Now().UTC()
+
+	if last, ok := f.touched.Load(tpl); ok && now.Sub(last.(time.Time)) < usageInterval { //nolint:forcetypeassert
+		return
+	}
+
+	f.touched.Store(tpl, now)
+
+	md, err := readMetadata(ctx, mc, tpl)
+	if err != nil {
+		return
+	}
+
+	md.LastUsedAt = now
+	_ = writeMetadata(ctx, mc, tpl, md)
+}
+
+// MigrationFingerprint returns the schema fingerprint of a database created
+// from the base template, or from scratch if base is empty, with
+// the migrator applied. The database is dropped afterwards.
+//
+// Unlike Migrator.Hash, it reflects what the migrator actually does, so
+// comparing it with the fingerprint of the template reveals templates
+// built by an older version of the migrator under the same hash.
+func (f *DBManager) MigrationFingerprint(ctx context.Context, migrator Migrator, base string) (string, error) {
+	s, err := f.DescribeMigration(ctx, migrator, base)
+	if err != nil {
+		return "", err
+	}
+
+	return s.Fingerprint(), nil
+}
+
+// DescribeMigration describes the schema of a database created from the base
+// template, or from scratch if base is empty, with the migrator applied.
+// If migrator is nil, the schema of the base template is described as is.
+// The database is dropped afterwards.
+func (f *DBManager) DescribeMigration(
+	ctx context.Context,
+	migrator Migrator,
+	base string,
+) (_ *catalog.Schema, err error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to generate database name: %w", err)
+	}
+
+	db, err := f.CreateDB(ctx, templateOrDefault(base), "scratch_"+hex.EncodeToString(suffix))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if dropErr := f.DropDB(context.WithoutCancel(ctx), db); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+	}()
+
+	conn, err := f.newConn(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if migrator != nil {
+		if err := migrator.Migrate(ctx, conn); err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to run migrations: %w", err)
+		}
+	}
+
+	s, err := catalog.Describe(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to describe schema: %w", err)
+	}
+
+	return s, nil
+}
+
+// templateOrDefault returns the template to create databases from, where
+// an empty base stands for the server default.
+func templateOrDefault(base string) string {
+	if base == "" {
+		return "template1"
+	}
+
+	return base
+}
+
+// fingerprint returns the schema fingerprint of the connected database.
+func fingerprint(ctx context.Context, conn *pgx.Conn) (string, error) {
+	s, err := catalog.Describe(ctx, conn)
+	if err != nil {
+		return "", fmt.Errorf("pgxephemeraltest: failed to describe schema: %w", err)
+	}
+
+	return s.Fingerprint(), nil
+}
+
+func readMetadata(ctx context.Context, mc *pgx.Conn, tpl string) (TemplateMetadata, error) {
+	var comment *string
+	if err := mc.QueryRow(
+		ctx,
+		"SELECT shobj_description(oid, 'pg_database') FROM pg_database WHERE datname = $1",
+		tpl,
+	).Scan(&comment); err != nil {
+		return TemplateMetadata{}, fmt.Errorf("pgxephemeraltest: failed to read template metadata: %w", err)
+	}
+
+	return parseMetadata(comment), nil
+}
+
+// parseMetadata parses the database comment. Comments that are not
+// metadata are treated as no metadata.
+func parseMetadata(comment *string) TemplateMetadata {
+	var md TemplateMetadata
+	if comment != nil {
+		_ = json.Unmarshal([]byte(*comment), &md)
+	}
+
+	return md
+}
+
+func writeMetadata(ctx context.Context, mc *pgx.Conn, tpl string, md TemplateMetadata) error {
+	b, err := json.Marshal(md)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to encode template metadata: %w", err)
+	}
+
+	// COMMENT does not accept parameters, so the statement is built
+	// server-side with the literal properly quoted.
+	var stmt string
+	if err := mc.QueryRow(
+		ctx,
+		"SELECT format('COMMENT ON DATABASE %I IS %L', $1::text, $2::text)",
+		tpl,
+		string(b),
+	).Scan(&stmt); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to write template metadata: %w", err)
+	}
+
+	if _, err := mc.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to write template metadata: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// tooManyConnections is the SQLSTATE of errors connecting to a server
+	// out of connection slots.
+	tooManyConnections = "53300"
+
+	// lockNotAvailable is the SQLSTATE of errors waiting for locks longer
+	// than lock_timeout.
+	lockNotAvailable = "55P03"
+)
+
+const (
+	// retryTimeout is the maximum time transient errors are retried for.
+	retryTimeout = 30 * time.Second
+
+	// retryBaseDelay and retryMaxDelay bound the backoff between retries.
+	retryBaseDelay = 50 * time.Millisecond
+	retryMaxDelay  = 2 * time.Second
+)
+
+// Logf logs messages of the manager, e.g., testing.TB.Logf.
+type Logf func(format string, args ...any)
+
+type logfKey struct{}
+
+// ContextWithLogf returns a context making the manager log diagnostics,
+// e.g., about retried operations, with logf.
+func ContextWithLogf(ctx context.Context, logf Logf) context.Context {
+	return context.WithValue(ctx, logfKey{}, logf)
+}
+
+func logf(ctx context.Context, format string, args ...any) {
+	if logf, ok := ctx.Value(logfKey{}).(Logf); ok {
+		logf(format, args...)
+	}
+}
+
+// isTransient reports whether the operation failed with err may succeed
+// when retried: the template is being accessed by other sessions, the server
+// is out of connection slots, or a lock timed out.
+func isTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case objectInUse, tooManyConnections, lockNotAvailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryTransient runs fn, retrying it with jittered exponential backoff
+// while it fails with transient errors, for up to retryTimeout or until ctx
+// is done.
+func retryTransient(ctx context.Context, op string, fn func() error) error {
+	deadline := time.Now().Add(retryTimeout)
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+
+		delay := backoff(attempt)
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		logf(ctx, "pgxephemeraltest: failed to %s, retrying in %s: %v", op, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the attempt+1 retry, drawn uniformly
+// from the exponentially growing range, so concurrent callers spread out.
+func backoff(attempt int) time.Duration {
+	ceiling := min(retryBaseDelay<<min(attempt, 16), retryMaxDelay)
+
+	return retryBaseDelay/2 + rand.N(ceiling) //nolint:gosec // jitter needs no secure randomness.
+}
+
+// logHolders logs the sessions connected to the db database, which make
+// copying it fail.
+func logHolders(ctx context.Context, mc *pgx.Conn, db string) {
+	rows, err := mc.Query(
+		ctx,
+		`SELECT
+  pid,
+  coalesce(usename, ''),
+  application_name,
+  coalesce(host(client_addr), 'local'),
+  coalesce(state, '')
+FROM pg_stat_activity
+WHERE datname = $1 AND pid <> pg_backend_pid()`,
+		db,
+	)
+	if err != nil {
+		return
+	}
+
+	var (
+		pid                          int32
+		user, app, client, stateName string
+	)
+
+	_, _ = pgx.ForEachRow(rows, []any{&pid, &user, &app, &client, &stateName}, func() error {
+		logf(ctx,
+			"pgxephemeraltest: database %s is accessed by backend %d "+
+				"(user %q, application %q, client %s, state %q)",
+			db, pid, user, app, client, stateName,
+		)
+
+		return nil
+	})
+}
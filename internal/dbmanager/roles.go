@@ -0,0 +1,276 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RoleComment marks roles created by EnsureRoles.
+const RoleComment = "pgxephemeraltest managed role"
+
+// rolesLock is the advisory lock name linearizing role provisioning, since
+// roles are shared by all databases of the cluster. The template lock taken
+// by Init is not reused: it is keyed by the template, so factories building
+// different templates would still race on CREATE ROLE, and roles are
+// provisioned before the template name is known.
+const rolesLock = TemplatePrefix + "roles"
+
+// duplicateObject is the SQLSTATE of errors creating existing roles.
+const duplicateObject = "42710"
+
+// ErrInvalidRoleAttribute is returned for unknown role attributes.
+var ErrInvalidRoleAttribute = errors.New("pgxephemeraltest: invalid role attribute")
+
+//nolint:gochecknoglobals // constant value.
+var roleAttributes = []string{
+	"SUPERUSER", "NOSUPERUSER",
+	"CREATEDB", "NOCREATEDB",
+	"CREATEROLE", "NOCREATEROLE",
+	"INHERIT", "NOINHERIT",
+	"REPLICATION", "NOREPLICATION",
+	"BYPASSRLS", "NOBYPASSRLS",
+}
+
+// Role describes a cluster role.
+type Role struct {
+	// Name is the role name.
+	Name string
+
+	// Login allows the role to log in.
+	Login bool
+
+	// Password is the password of the role, if any.
+	Password string
+
+	// Attributes are role attributes, e.g., "BYPASSRLS" or "CREATEDB".
+	Attributes []string
+
+	// MemberOf are roles the role is granted membership in.
+	MemberOf []string
+}
+
+// EnsureRoles creates the roles, unless they exist, and grants them
+// memberships.
+//
+// Roles are created under an advisory lock, so concurrent calls do not race
+// on CREATE ROLE, and are marked with RoleComment. Existing roles created by
+// EnsureRoles are updated to match the description, while roles created
+// otherwise are left intact, except for memberships.
+func (f *DBManager) EnsureRoles(ctx context.Context, roles []Role) (err error) {
+	for _, role := range roles {
+		for _, attr := range role.Attributes {
+			if !slices.Contains(roleAttributes, strings.ToUpper(attr)) {
+				return fmt.Errorf("%w %q of role %q", ErrInvalidRoleAttribute, attr, role.Name)
+			}
+		}
+	}
+
+	pc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer pc.Release()
+
+	mc := pc.Conn()
+
+	releaseLock, err := acquireLock(ctx, mc, rolesLock)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to take lock: %w", err)
+	}
+
+	defer func() {
+		if releaseErr := releaseLock(context.WithoutCancel(ctx)); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+	}()
+
+	for _, role := range roles {
+		if err := ensureRole(ctx, mc, role); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to create role %q: %w", role.Name, err)
+		}
+	}
+
+	for _, role := range roles {
+		if err := grantMemberships(ctx, mc, role); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to grant roles to %q: %w", role.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func grantMemberships(ctx context.Context, mc *pgx.Conn, role Role) error {
+	for _, parent := range role.MemberOf {
+		if _, err := mc.Exec(ctx, strings.Join([]string{
+			"GRANT",
+			pgx.Identifier{parent}.Sanitize(),
+			"TO",
+			pgx.Identifier{role.Name}.Sanitize(),
+		}, " ")); err != nil {
+			return fmt.Errorf("grant %q: %w", parent, err)
+		}
+	}
+
+	return nil
+}
+
+func ensureRole(ctx context.Context, mc *pgx.Conn, role Role) error {
+	exists, managed := true, false
+	if err := mc.QueryRow(
+		ctx,
+		"SELECT coalesce(shobj_description(oid, 'pg_authid') = $2, false) FROM pg_roles WHERE rolname = $1",
+		role.Name,
+		RoleComment,
+	).Scan(&managed); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("check role: %w", err)
+		}
+
+		exists = false
+	}
+
+	if exists && !managed {
+		return nil // Not ours, leave it intact.
+	}
+
+	options, err := roleOptions(ctx, mc, role)
+	if err != nil {
+		return err
+	}
+
+	stmt := "CREATE ROLE "
+	if exists {
+		stmt = "ALTER ROLE "
+	}
+
+	if _, err := mc.Exec(ctx, stmt+pgx.Identifier{role.Name}.Sanitize()+" WITH "+options); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == duplicateObject {
+			return nil // Created by someone else in the meantime.
+		}
+
+		return fmt.Errorf("create role: %w", err)
+	}
+
+	if exists {
+		return nil
+	}
+
+	if _, err := mc.Exec(
+		ctx,
+		"COMMENT ON ROLE "+pgx.Identifier{role.Name}.Sanitize()+" IS '"+RoleComment+"'",
+	); err != nil {
+		return fmt.Errorf("mark role: %w", err)
+	}
+
+	return nil
+}
+
+// roleOptions returns the options clause of CREATE ROLE for the role.
+func roleOptions(ctx context.Context, mc *pgx.Conn, role Role) (string, error) {
+	options := []string{"NOLOGIN"}
+	if role.Login {
+		options[0] = "LOGIN"
+	}
+
+	for _, attr := range role.Attributes {
+		options = append(options, strings.ToUpper(attr))
+	}
+
+	if role.Password != "" {
+		// The password is quoted server-side to respect the server
+		// settings affecting string literals.
+		var password string
+
+		err := mc.QueryRow(ctx, "SELECT quote_literal($1::text)", role.Password).Scan(&password)
+		if err != nil {
+			return "", fmt.Errorf("quote password: %w", err)
+		}
+
+		options = append(options, "PASSWORD", password)
+	}
+
+	return strings.Join(options, " "), nil
+}
+
+// ListRoles lists roles created by EnsureRoles.
+func (f *DBManager) ListRoles(ctx context.Context) ([]string, error) {
+	mc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer mc.Release()
+
+	rows, err := mc.Query(
+		ctx,
+		"SELECT rolname FROM pg_roles WHERE shobj_description(oid, 'pg_authid') = $1 ORDER BY rolname",
+		RoleComment,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to list roles: %w", err)
+	}
+
+	roles, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// DropRoles drops roles created by EnsureRoles. Roles that still own objects
+// or hold privileges in any database, e.g., in templates, cannot be dropped,
+// so databases are to be dropped first.
+func (f *DBManager) DropRoles(ctx context.Context, roles []string) error {
+	managed, err := f.ListRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if !slices.Contains(managed, role) {
+			return fmt.Errorf("pgxephemeraltest: refusing to drop unmanaged role %q", role)
+		}
+	}
+
+	mc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer mc.Release()
+
+	for _, role := range roles {
+		if _, err := mc.Exec(ctx, "DROP ROLE IF EXISTS "+pgx.Identifier{role}.Sanitize()); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to drop role %q: %w", role, err)
+		}
+	}
+
+	return nil
+}
+
+// GrantConnect grants the role to connect to the db database and create
+// temporary tables in it.
+func (f *DBManager) GrantConnect(ctx context.Context, db, role string) error {
+	mc, err := f.acquireMaintenanceConn(ctx)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to acquire maintenance connection: %w", err)
+	}
+	defer mc.Release()
+
+	if _, err := mc.Exec(ctx, strings.Join([]string{
+		"GRANT CONNECT, TEMPORARY ON DATABASE",
+		pgx.Identifier{db}.Sanitize(),
+		"TO",
+		pgx.Identifier{role}.Sanitize(),
+	}, " ")); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to grant access to database %s: %w", db, err)
+	}
+
+	return nil
+}
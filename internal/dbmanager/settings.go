@@ -0,0 +1,60 @@
+package dbmanager
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// applySettings sets the configuration parameters of the manager on the db
+// database.
+func (f *DBManager) applySettings(ctx context.Context, mc *pgx.Conn, db string) error {
+	if len(f.settings) == 0 {
+		return nil
+	}
+
+	var b pgx.Batch
+
+	for _, name := range slices.Sorted(maps.Keys(f.settings)) {
+		// Custom parameters are qualified, e.g., app.tenant_id.
+		param := pgx.Identifier(strings.Split(name, ".")).Sanitize()
+
+		// ALTER DATABASE does not accept parameters, so the statement is
+		// built server-side with the value properly quoted.
+		b.Queue(
+			"SELECT format('ALTER DATABASE %I SET %s TO %L', $1::text, $2::text, $3::text)",
+			db,
+			param,
+			f.settings[name],
+		)
+	}
+
+	stmts := make([]string, 0, b.Len())
+
+	results := mc.SendBatch(ctx, &b)
+	for range b.Len() {
+		var stmt string
+		if err := results.QueryRow().Scan(&stmt); err != nil {
+			_ = results.Close()
+			return fmt.Errorf("pgxephemeraltest: failed to prepare database settings: %w", err)
+		}
+
+		stmts = append(stmts, stmt)
+	}
+
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to prepare database settings: %w", err)
+	}
+
+	for _, stmt := range stmts {
+		if _, err := mc.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to apply database settings: %w", err)
+		}
+	}
+
+	return nil
+}
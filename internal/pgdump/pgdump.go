@@ -0,0 +1,300 @@
+// Package pgdump parses plain-format pg_dump output.
+//
+// Unlike generic SQL splitters, the parser understands COPY ... FROM stdin
+// data sections terminated by \. and psql meta-commands, such as \connect
+// or \restrict, which pg_dump emits.
+package pgdump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Kind is the kind of a statement.
+type Kind int
+
+const (
+	// KindSQL is an SQL statement.
+	KindSQL Kind = iota
+
+	// KindCopy is a COPY ... FROM stdin statement followed by data.
+	KindCopy
+
+	// KindMeta is a psql meta-command, e.g., \connect.
+	KindMeta
+)
+
+// ErrUnterminated is returned when the dump ends in the middle of a quoted
+// literal, a comment or a COPY data section.
+var ErrUnterminated = errors.New("unterminated input")
+
+var (
+	copyFromStdinRe = regexp.MustCompile(`(?is)^\s*COPY\s.*\sFROM\s+stdin\b`)
+	dollarTagRe     = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+)
+
+// Statement is a statement of a dump.
+type Statement struct {
+	Kind Kind
+
+	// SQL is the statement text with comments removed, or the meta-command
+	// line for KindMeta statements.
+	SQL string
+
+	// Line is the 1-based line the statement starts at.
+	Line int
+
+	// Data reads COPY data of KindCopy statements, excluding the \.
+	// terminator. It must be consumed before the next call to Next,
+	// otherwise the rest of the data is skipped.
+	Data io.Reader
+}
+
+// Parser reads statements of a dump.
+type Parser struct {
+	r *bufio.Reader
+	// line is the number of the last read line.
+	line int
+	// pending is the rest of the last read line following a statement
+	// terminator.
+	pending string
+	// data is the COPY data reader of the last statement.
+	data *copyReader
+}
+
+// NewParser returns a parser reading the dump from r.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{r: bufio.NewReader(r), line: 0, pending: "", data: nil}
+}
+
+// lexState is the lexical state of a statement spanning multiple lines.
+type lexState struct {
+	// quote is the opening quote of the current literal or identifier.
+	quote byte
+	// escapes indicates an E'...' string with backslash escapes.
+	escapes bool
+	// dollarTag is the tag of the current dollar-quoted string.
+	dollarTag string
+	// commentDepth is the nesting depth of the current block comment.
+	commentDepth int
+}
+
+func (s lexState) inside() bool { return s.quote != 0 || s.dollarTag != "" || s.commentDepth > 0 }
+
+// Next returns the next statement, or io.EOF if the dump is exhausted.
+func (p *Parser) Next() (Statement, error) {
+	if p.data != nil {
+		if _, err := io.Copy(io.Discard, p.data); err != nil {
+			return Statement{}, err
+		}
+
+		p.data = nil
+	}
+
+	var (
+		sql     strings.Builder
+		state   lexState
+		hasCode bool
+		start   int
+	)
+
+	for {
+		line, err := p.readLine()
+		if errors.Is(err, io.EOF) {
+			if state.inside() {
+				return Statement{}, fmt.Errorf("statement at line %d: %w", start, ErrUnterminated)
+			}
+
+			if hasCode {
+				return sqlStatement(&sql, start), nil
+			}
+
+			return Statement{}, io.EOF
+		}
+
+		if err != nil {
+			return Statement{}, err
+		}
+
+		if !hasCode && !state.inside() {
+			start = p.line
+
+			if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, `\`) {
+				return Statement{Kind: KindMeta, SQL: trimmed, Line: start, Data: nil}, nil
+			}
+		}
+
+		rest, done := scanLine(line, &sql, &state, &hasCode)
+		if !done {
+			continue
+		}
+
+		if strings.TrimSpace(rest) != "" {
+			p.pending = rest
+		}
+
+		stmt := sqlStatement(&sql, start)
+		if copyFromStdinRe.MatchString(stmt.SQL) {
+			p.data = &copyReader{p: p, buf: "", done: false}
+			stmt.Kind = KindCopy
+			stmt.Data = p.data
+		}
+
+		return stmt, nil
+	}
+}
+
+func sqlStatement(sql *strings.Builder, line int) Statement {
+	return Statement{Kind: KindSQL, SQL: strings.TrimSpace(sql.String()), Line: line, Data: nil}
+}
+
+// readLine returns the next line including the line terminator.
+func (p *Parser) readLine() (string, error) {
+	if p.pending != "" {
+		line := p.pending
+		p.pending = ""
+
+		return line, nil
+	}
+
+	line, err := p.r.ReadString('\n')
+	if errors.Is(err, io.EOF) && line != "" {
+		err = nil
+	}
+
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", io.EOF
+		}
+
+		return "", fmt.Errorf("read line %d: %w", p.line+1, err)
+	}
+
+	p.line++
+
+	return line, nil
+}
+
+// scanLine appends line to sql with comments removed, until the statement
+// terminator. It reports whether the statement is complete, and the rest
+// of the line following the terminator.
+func scanLine(line string, sql *strings.Builder, s *lexState, hasCode *bool) (string, bool) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case s.commentDepth > 0:
+			switch {
+			case strings.HasPrefix(line[i:], "/*"):
+				s.commentDepth++
+				i++
+			case strings.HasPrefix(line[i:], "*/"):
+				s.commentDepth--
+				i++
+
+				if s.commentDepth == 0 {
+					sql.WriteByte(' ')
+				}
+			}
+		case s.dollarTag != "":
+			if strings.HasPrefix(line[i:], s.dollarTag) {
+				sql.WriteString(s.dollarTag)
+				i += len(s.dollarTag) - 1
+				s.dollarTag = ""
+			} else {
+				sql.WriteByte(c)
+			}
+		case s.quote != 0:
+			sql.WriteByte(c)
+
+			switch {
+			case c == '\\' && s.escapes && i+1 < len(line):
+				i++
+				sql.WriteByte(line[i])
+			case c == s.quote && i+1 < len(line) && line[i+1] == s.quote:
+				// Doubled quote.
+				i++
+				sql.WriteByte(line[i])
+			case c == s.quote:
+				s.quote = 0
+			}
+		case strings.HasPrefix(line[i:], "--"):
+			sql.WriteByte('\n')
+
+			return "", false
+		case strings.HasPrefix(line[i:], "/*"):
+			s.commentDepth = 1
+			i++
+		case c == '\'' || c == '"':
+			s.quote = c
+			s.escapes = c == '\'' && i > 0 && (line[i-1] == 'E' || line[i-1] == 'e') &&
+				(i < 2 || !isIdentChar(line[i-2]))
+			*hasCode = true
+
+			sql.WriteByte(c)
+		case c == '$' && (i == 0 || !isIdentChar(line[i-1])) && dollarTagRe.MatchString(line[i:]):
+			s.dollarTag = dollarTagRe.FindString(line[i:])
+			*hasCode = true
+
+			sql.WriteString(s.dollarTag)
+			i += len(s.dollarTag) - 1
+		case c == ';':
+			sql.WriteByte(c)
+
+			return line[i+1:], true
+		default:
+			if !unicode.IsSpace(rune(c)) {
+				*hasCode = true
+			}
+
+			sql.WriteByte(c)
+		}
+	}
+
+	return "", false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// copyReader reads COPY data lines up to the \. terminator.
+type copyReader struct {
+	p    *Parser
+	buf  string
+	done bool
+}
+
+func (r *copyReader) Read(b []byte) (int, error) {
+	for r.buf == "" {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		line, err := r.p.readLine()
+		if errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("COPY data at line %d: %w", r.p.line, ErrUnterminated)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if strings.TrimRight(line, "\r\n") == `\.` {
+			r.done = true
+			continue
+		}
+
+		r.buf = line
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
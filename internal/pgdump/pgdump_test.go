@@ -0,0 +1,129 @@
+package pgdump
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dump = `--
+-- PostgreSQL database dump
+--
+
+\restrict abc123
+
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+/* a block /* nested */ comment; */
+CREATE FUNCTION public.f() RETURNS text
+    LANGUAGE sql
+    AS $_$SELECT 'a;b'$_$;
+
+CREATE TABLE public.kv (
+    key text NOT NULL, -- the key; really
+    value text DEFAULT E'it\'s;'
+);
+
+COPY public.kv (key, value) FROM stdin;
+a	1
+b	\N
+\.
+
+ALTER TABLE public.kv OWNER TO "app;owner";
+
+\unrestrict abc123
+`
+
+func TestParser(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it parses statements, COPY data and meta-commands", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		p := NewParser(strings.NewReader(dump))
+
+		var (
+			kinds []Kind
+			sqls  []string
+			data  string
+		)
+
+		// Act
+		for {
+			stmt, err := p.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			require.NoError(t, err)
+
+			kinds = append(kinds, stmt.Kind)
+			sqls = append(sqls, stmt.SQL)
+
+			if stmt.Kind == KindCopy {
+				b, err := io.ReadAll(stmt.Data)
+				require.NoError(t, err)
+
+				data = string(b)
+			}
+		}
+
+		// Assert
+		assert.Equal(t, []Kind{
+			KindMeta, KindSQL, KindSQL, KindSQL, KindSQL, KindCopy, KindSQL, KindMeta,
+		}, kinds)
+		assert.Equal(t, `\restrict abc123`, sqls[0])
+		assert.Equal(t, "SET statement_timeout = 0;", sqls[1])
+		assert.Equal(t, "CREATE FUNCTION public.f() RETURNS text\n"+
+			"    LANGUAGE sql\n"+
+			"    AS $_$SELECT 'a;b'$_$;", sqls[3])
+		assert.Contains(t, sqls[4], `DEFAULT E'it\'s;'`)
+		assert.NotContains(t, sqls[4], "really")
+		assert.Equal(t, "COPY public.kv (key, value) FROM stdin;", sqls[5])
+		assert.Equal(t, "a\t1\nb\t\\N\n", data)
+		assert.Equal(t, `ALTER TABLE public.kv OWNER TO "app;owner";`, sqls[6])
+	})
+
+	t.Run("it skips unread COPY data", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		p := NewParser(strings.NewReader("COPY t FROM stdin;\n1\n\\.\nSELECT 1;\n"))
+
+		// Act
+		first, err := p.Next()
+		require.NoError(t, err)
+
+		second, err := p.Next()
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, KindCopy, first.Kind)
+		assert.Equal(t, "SELECT 1;", second.SQL)
+		assert.Equal(t, 4, second.Line)
+	})
+
+	t.Run("it reports unterminated input", func(t *testing.T) {
+		t.Parallel()
+
+		for _, input := range []string{"SELECT 'a;\n", "COPY t FROM stdin;\n1\n"} {
+			// Arrange
+			p := NewParser(strings.NewReader(input))
+
+			// Act
+			stmt, err := p.Next()
+			if err == nil {
+				_, err = io.ReadAll(stmt.Data)
+			}
+
+			// Assert
+			require.ErrorIs(t, err, ErrUnterminated, input)
+		}
+	})
+}
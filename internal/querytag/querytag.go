@@ -0,0 +1,19 @@
+// Package querytag tags queries issued by the library itself, so that
+// they are told apart from queries of the code under test.
+package querytag
+
+import "context"
+
+type internalKey struct{}
+
+// Internal returns a context tagging queries executed with it as internal.
+func Internal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalKey{}, true)
+}
+
+// IsInternal reports whether queries executed with ctx are internal.
+func IsInternal(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalKey{}).(bool)
+
+	return internal
+}
@@ -81,6 +81,47 @@ func PoolConfig(tb testing.TB) *pgxpool.Config {
 	return config
 }
 
+// DropOnCleanup drops the templates and then the roles once the test
+// and its subtests are done, unless the test failed. It must be called
+// before the test requests pools, so that their databases, which may refer
+// to the roles, are dropped first.
+func DropOnCleanup(tb testing.TB, config *pgxpool.Config, templates []string, roles ...string) {
+	tb.Helper()
+
+	tb.Cleanup(func() {
+		if tb.Failed() {
+			return
+		}
+
+		ctx := context.Background()
+
+		conn, err := pgx.ConnectConfig(ctx, config.ConnConfig)
+		if err != nil {
+			tb.Errorf("failed to connect to database: %v", err)
+			return
+		}
+		defer conn.Close(ctx)
+
+		for _, tpl := range templates {
+			_, err := conn.Exec(ctx, "UPDATE pg_database SET datistemplate = false WHERE datname = $1", tpl)
+			if err == nil {
+				_, err = conn.Exec(ctx, "DROP DATABASE IF EXISTS "+pgx.Identifier{tpl}.Sanitize())
+			}
+
+			if err != nil {
+				tb.Errorf("failed to drop template %s: %v", tpl, err)
+			}
+		}
+
+		for _, role := range roles {
+			_, err := conn.Exec(ctx, "DROP ROLE IF EXISTS "+pgx.Identifier{role}.Sanitize())
+			if err != nil {
+				tb.Errorf("failed to drop role %s: %v", role, err)
+			}
+		}
+	})
+}
+
 type KV struct {
 	Key   string
 	Value string
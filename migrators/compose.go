@@ -0,0 +1,169 @@
+package migrators
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+var (
+	_ dbmanager.Migrator = (*ChainMigrator)(nil)
+	_ dbmanager.Lineager = (*ChainMigrator)(nil)
+	_ dbmanager.Migrator = (*FuncMigrator)(nil)
+	_ dbmanager.Lineager = (*FuncMigrator)(nil)
+	_ dbmanager.Migrator = (*TxMigrator)(nil)
+	_ dbmanager.Lineager = (*TxMigrator)(nil)
+)
+
+// ChainMigrator runs migrators in order.
+type ChainMigrator struct {
+	migrators []dbmanager.Migrator
+	hash      string
+}
+
+// Chain returns a migrator running the migrators in order on the same
+// connection, e.g., installing extensions, then applying the schema, then
+// loading seed data.
+//
+// Its hash combines the hashes of the migrators, so that changing, adding,
+// removing or reordering any of them changes the hash, and hashes of
+// different chains cannot collide by concatenation.
+func Chain(migrators ...dbmanager.Migrator) *ChainMigrator {
+	h := sha256.New()
+	writeHashField(h, "chain")
+
+	for _, m := range migrators {
+		writeHashField(h, m.Hash())
+	}
+
+	return &ChainMigrator{migrators: migrators, hash: hex.EncodeToString(h.Sum(nil))}
+}
+
+func (m *ChainMigrator) Hash() string { return m.hash }
+
+// Lineage combines the lineages of the migrators, so that chains of
+// different migrators do not share the lineage. The chain has no lineage
+// if any of the migrators has none.
+func (m *ChainMigrator) Lineage() string {
+	lineages := make([]string, len(m.migrators))
+	for i, migrator := range m.migrators {
+		lineages[i] = lineage(migrator)
+		if lineages[i] == "" {
+			return ""
+		}
+	}
+
+	return "chain(" + strings.Join(lineages, ", ") + ")"
+}
+
+// Migrate runs the migrators in order, stopping at the first error.
+func (m *ChainMigrator) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	for i, migrator := range m.migrators {
+		if err := migrator.Migrate(ctx, conn); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to run migrator %d of chain: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// FuncMigrator is a migrator built from a function.
+type FuncMigrator struct {
+	hash    string
+	lineage string
+	fn      func(context.Context, *pgx.Conn) error
+}
+
+// Func returns a migrator running fn, identified by hash. The hash must be
+// changed whenever fn changes what it does.
+//
+// The migrator has no lineage unless set by WithLineage, so neither have
+// chains including it.
+func Func(hash string, fn func(context.Context, *pgx.Conn) error) *FuncMigrator {
+	return &FuncMigrator{hash: hash, lineage: "", fn: fn}
+}
+
+// WithLineage returns a copy of the migrator with the lineage set, which
+// must stay the same when fn and the hash change.
+func (m *FuncMigrator) WithLineage(lineage string) *FuncMigrator {
+	c := *m
+	c.lineage = lineage
+
+	return &c
+}
+
+func (m *FuncMigrator) Hash() string { return m.hash }
+
+func (m *FuncMigrator) Lineage() string { return m.lineage }
+
+func (m *FuncMigrator) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	return m.fn(ctx, conn)
+}
+
+// TxMigrator runs a migrator in a transaction.
+type TxMigrator struct {
+	m dbmanager.Migrator
+}
+
+// InTx returns a migrator running m in a transaction, so that a failed
+// template build leaves nothing half-applied.
+//
+// The transaction is open on the connection passed to m, so m must not
+// manage transactions itself, and must not run statements that cannot run
+// in a transaction, such as CREATE INDEX CONCURRENTLY.
+//
+// The hash and the lineage are the ones of m.
+func InTx(m dbmanager.Migrator) *TxMigrator { return &TxMigrator{m: m} }
+
+func (m *TxMigrator) Hash() string { return m.m.Hash() }
+
+func (m *TxMigrator) Lineage() string { return lineage(m.m) }
+
+// Migrate runs the migrator in a transaction, committing it on success
+// and rolling it back on error.
+func (m *TxMigrator) Migrate(ctx context.Context, conn *pgx.Conn) (err error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// A failed commit closes the transaction, so there is nothing to
+		// roll back.
+		rbErr := tx.Rollback(context.WithoutCancel(ctx))
+		if rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+			rbErr = fmt.Errorf("pgxephemeraltest: failed to rollback transaction: %w", rbErr)
+			err = errors.Join(err, rbErr)
+		}
+	}()
+
+	if err := m.m.Migrate(ctx, conn); err != nil {
+		return err //nolint:wrapcheck // reported as is.
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// lineage returns the lineage reported by the migrator, if any.
+func lineage(m dbmanager.Migrator) string {
+	if l, ok := m.(dbmanager.Lineager); ok {
+		return l.Lineage()
+	}
+
+	return ""
+}
@@ -0,0 +1,159 @@
+package migrators_test
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it combines hashes unambiguously", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		ab := testutil.NewMigrator("", "ab")
+		c := testutil.NewMigrator("", "c")
+		a := testutil.NewMigrator("", "a")
+		bc := testutil.NewMigrator("", "bc")
+
+		// Act
+		first := migrators.Chain(ab, c)
+		second := migrators.Chain(a, bc)
+		reversed := migrators.Chain(c, ab)
+
+		// Assert
+		assert.Equal(t, first.Hash(), migrators.Chain(ab, c).Hash())
+		assert.NotEqual(t, first.Hash(), second.Hash())
+		assert.NotEqual(t, first.Hash(), reversed.Hash())
+		assert.NotEqual(t, migrators.Chain(ab).Hash(), ab.Hash())
+	})
+
+	t.Run("it combines lineages of function migrators", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		noop := func(context.Context, *pgx.Conn) error { return nil }
+		seed := migrators.Func("seed-v1", noop)
+
+		// Act
+		schema := migrators.Func("schema-v1", noop).WithLineage("schema")
+		chain := migrators.Chain(seed.WithLineage("seed"), schema)
+
+		// Assert
+		assert.Empty(t, seed.Lineage())
+		assert.Equal(t, "chain(seed, schema)", chain.Lineage())
+		assert.Empty(t, migrators.Chain(seed, chain).Lineage())
+	})
+
+	t.Run("it runs migrators in order", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		suffix := strconv.FormatInt(rand.Int64(), 10) // #nosec G404
+		m := migrators.Chain(
+			testutil.NewMigrator(testutil.KVSchema, "chain-kv-"+suffix),
+			migrators.Func("chain-seed-"+suffix, func(ctx context.Context, conn *pgx.Conn) error {
+				_, err := conn.Exec(ctx, "INSERT INTO kv (key, value) VALUES ('seeded', 'yes')")
+				return err
+			}),
+		)
+
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), testutil.PoolConfig(t), m)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Act
+		pool := f.Pool(t)
+
+		// Assert
+		var value string
+
+		err = pool.QueryRow(t.Context(), "SELECT value FROM kv WHERE key = 'seeded'").Scan(&value)
+		require.NoError(t, err)
+		assert.Equal(t, "yes", value)
+	})
+}
+
+func TestInTx(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it rolls back a failed migration", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		errBroken := errors.New("broken seed")
+
+		config := testutil.PoolConfig(t)
+
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, testutil.NewNoopMigrator())
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		pool := f.Pool(t)
+
+		conn, err := pool.Acquire(t.Context())
+		require.NoError(t, err)
+
+		defer conn.Release()
+
+		m := migrators.InTx(migrators.Chain(
+			testutil.NewMigrator(testutil.KVSchema, "tx-kv"),
+			migrators.Func("tx-broken", func(context.Context, *pgx.Conn) error { return errBroken }),
+		))
+
+		// Act
+		err = m.Migrate(t.Context(), conn.Conn())
+
+		// Assert
+		require.ErrorIs(t, err, errBroken)
+
+		var exists bool
+
+		err = conn.QueryRow(t.Context(), "SELECT to_regclass('kv') IS NOT NULL").Scan(&exists)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("it reports a failed commit without a rollback error", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, testutil.NewNoopMigrator())
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		conn, err := f.Pool(t).Acquire(t.Context())
+		require.NoError(t, err)
+
+		defer conn.Release()
+
+		m := migrators.InTx(migrators.Func("tx-deferred", func(ctx context.Context, conn *pgx.Conn) error {
+			_, err := conn.Exec(ctx, `CREATE TABLE deferred (id INT UNIQUE DEFERRABLE INITIALLY DEFERRED);
+INSERT INTO deferred (id) VALUES (1), (1)`)
+
+			return err
+		}))
+
+		// Act
+		err = m.Migrate(t.Context(), conn.Conn())
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to commit transaction")
+		assert.NotContains(t, err.Error(), "rollback")
+	})
+}
@@ -0,0 +1,204 @@
+// Package migrators provides pgxephemeraltest.Migrator implementations
+// for preparing template databases.
+package migrators
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+var _ dbmanager.Migrator = (*CopyMigrator)(nil)
+
+// Format is the format of a data file.
+type Format int
+
+const (
+	// FormatAuto detects the format by the file extension: files ending
+	// with .csv are CSV files, the rest are COPY text files.
+	FormatAuto Format = iota
+
+	// FormatCSV is the CSV format with a header row listing columns.
+	FormatCSV
+
+	// FormatText is the Postgres COPY text format, as produced by
+	// COPY ... TO STDOUT and pg_dump.
+	FormatText
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatAuto:
+		return "auto"
+	case FormatCSV:
+		return "csv"
+	case FormatText:
+		return "text"
+	default:
+		return "Format(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// CopyFile describes a data file loaded into a table.
+type CopyFile struct {
+	// Path is the path of the file in the file system.
+	Path string
+
+	// Table is the possibly schema-qualified table name, e.g., "geo.countries".
+	// Defaults to the file name without the extension.
+	Table string
+
+	// Format is the format of the file, detected by the extension
+	// by default.
+	Format Format
+
+	// Columns are the columns of text format files, in the order of
+	// the file fields. Defaults to all table columns. Columns of CSV files
+	// are taken from the header row.
+	Columns []string
+}
+
+// CopyMigrator streams data files into tables with COPY.
+//
+// It is meant to load large reference datasets into the template database
+// once, so that each ephemeral database gets a copy of the data for free.
+type CopyMigrator struct {
+	fsys  fs.FS
+	files []CopyFile
+	hash  string
+}
+
+// CopyFiles returns a migrator loading files from fsys, in the given order.
+//
+// Contents of the files are hashed eagerly, so that changing the data leads
+// to a new template.
+func CopyFiles(fsys fs.FS, files ...CopyFile) (*CopyMigrator, error) {
+	h := sha256.New()
+	resolved := make([]CopyFile, len(files))
+
+	for i, f := range files {
+		if f.Table == "" {
+			f.Table = strings.TrimSuffix(path.Base(f.Path), path.Ext(f.Path))
+		}
+
+		if f.Format == FormatAuto {
+			f.Format = FormatText
+			if strings.EqualFold(path.Ext(f.Path), ".csv") {
+				f.Format = FormatCSV
+			}
+		}
+
+		if err := hashCopyFile(h, fsys, f); err != nil {
+			return nil, err
+		}
+
+		resolved[i] = f
+	}
+
+	return &CopyMigrator{fsys: fsys, files: resolved, hash: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func hashCopyFile(h hash.Hash, fsys fs.FS, f CopyFile) error {
+	writeHashField(h, f.Table)
+	writeHashField(h, f.Format.String())
+	writeHashField(h, strings.Join(f.Columns, ","))
+
+	file, err := fsys.Open(f.Path)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to open data file %q: %w", f.Path, err)
+	}
+	defer file.Close()
+
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to read data file %q: %w", f.Path, err)
+	}
+
+	writeHashField(h, strconv.FormatInt(n, 10))
+
+	return nil
+}
+
+// writeHashField writes a length-prefixed field, so that adjacent fields
+// cannot produce the same hash input.
+func writeHashField(h hash.Hash, s string) {
+	h.Write([]byte(strconv.Itoa(len(s)) + ":" + s))
+}
+
+// Hash returns a hash of the files contents and their target tables.
+func (m *CopyMigrator) Hash() string { return m.hash }
+
+// Migrate streams the files into their tables.
+func (m *CopyMigrator) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	for _, f := range m.files {
+		if err := m.copyFile(ctx, conn, f); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to copy %q into %s: %w", f.Path, f.Table, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *CopyMigrator) copyFile(ctx context.Context, conn *pgx.Conn, f CopyFile) error {
+	file, err := m.fsys.Open(f.Path)
+	if err != nil {
+		return fmt.Errorf("open data file: %w", err)
+	}
+	defer file.Close()
+
+	var (
+		r       io.Reader = file
+		cols              = f.Columns
+		options           = "FORMAT text"
+	)
+
+	if f.Format == FormatCSV {
+		cols, r, err = csvHeader(file)
+		if err != nil {
+			return err
+		}
+
+		options = "FORMAT csv, HEADER true"
+	}
+
+	sql := "COPY " + pgx.Identifier(strings.Split(f.Table, ".")).Sanitize()
+	if len(cols) > 0 {
+		sql += " (" + catalog.QuoteIdentifiers(cols) + ")"
+	}
+
+	sql += " FROM STDIN WITH (" + options + ")"
+
+	if _, err := conn.PgConn().CopyFrom(ctx, r, sql); err != nil {
+		return fmt.Errorf("copy rows: %w", err)
+	}
+
+	return nil
+}
+
+// csvHeader reads columns from the header row of the CSV file. It returns
+// a reader of the entire file, including the header row.
+func csvHeader(file io.Reader) ([]string, io.Reader, error) {
+	var buf strings.Builder
+
+	cols, err := csv.NewReader(io.TeeReader(file, &buf)).Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CSV header: %w", err)
+	}
+
+	// The CSV reader buffers input past the header, replay the consumed
+	// part before the rest of the file.
+	return cols, io.MultiReader(strings.NewReader(buf.String()), file), nil
+}
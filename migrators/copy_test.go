@@ -0,0 +1,99 @@
+package migrators_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+const currenciesSchema = `
+CREATE SCHEMA geo;
+CREATE TABLE currencies (code TEXT PRIMARY KEY, name TEXT NOT NULL, digits INT);
+CREATE TABLE geo.countries (code TEXT PRIMARY KEY, currency TEXT NOT NULL REFERENCES currencies (code));`
+
+//nolint:gochecknoglobals // test data.
+var dataFS = fstest.MapFS{
+	"currencies.csv": {Data: []byte("name,code,digits\nEuro,EUR,2\n\"Yen, Japanese\",JPY,\n")},
+	"countries.copy": {Data: []byte("DE\tEUR\nJP\tJPY\n")},
+}
+
+func TestCopyFiles(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it hashes file contents and targets", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		changed := fstest.MapFS{
+			"currencies.csv": dataFS["currencies.csv"],
+			"countries.copy": {Data: []byte("DE\tEUR\n")},
+		}
+
+		countries := migrators.CopyFile{Path: "countries.copy", Table: "geo.countries"}
+
+		// Act
+		a, err := migrators.CopyFiles(dataFS, countries)
+		require.NoError(t, err)
+
+		b, err := migrators.CopyFiles(changed, countries)
+		require.NoError(t, err)
+
+		c, err := migrators.CopyFiles(dataFS, migrators.CopyFile{Path: "countries.copy"})
+		require.NoError(t, err)
+
+		// Assert
+		assert.NotEqual(t, a.Hash(), b.Hash())
+		assert.NotEqual(t, a.Hash(), c.Hash())
+	})
+
+	t.Run("it fails on missing files", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		_, err := migrators.CopyFiles(dataFS, migrators.CopyFile{Path: "missing.csv"})
+
+		// Assert
+		require.Error(t, err)
+	})
+
+	t.Run("it loads CSV and text files into the template", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		data, err := migrators.CopyFiles(
+			dataFS,
+			migrators.CopyFile{Path: "currencies.csv"},
+			migrators.CopyFile{Path: "countries.copy", Table: "geo.countries"},
+		)
+		require.NoError(t, err)
+
+		m := migrators.Chain(testutil.NewMigrator(currenciesSchema, "currencies"), data)
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), testutil.PoolConfig(t), m)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Act
+		pool := f.Pool(t)
+
+		// Assert
+		var (
+			name   string
+			digits *int
+		)
+
+		err = pool.QueryRow(t.Context(), `
+SELECT cur.name, cur.digits
+FROM geo.countries c
+JOIN currencies cur ON cur.code = c.currency
+WHERE c.code = 'JP'`).Scan(&name, &digits)
+		require.NoError(t, err)
+		assert.Equal(t, "Yen, Japanese", name)
+		assert.Nil(t, digits)
+	})
+}
@@ -0,0 +1,154 @@
+package migrators
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/pgdump"
+)
+
+var _ dbmanager.Migrator = (*DumpMigrator)(nil)
+
+var (
+	ownerToRe   = regexp.MustCompile(`(?is)^ALTER\s.*\sOWNER\s+TO\s`)
+	privilegeRe = regexp.MustCompile(`(?is)^(GRANT|REVOKE)\s`)
+)
+
+// DumpOption configures DumpMigrator.
+type DumpOption func(*dumpOptions)
+
+type dumpOptions struct {
+	noOwner      bool
+	noPrivileges bool
+}
+
+// WithoutOwnership skips ALTER ... OWNER TO statements, like
+// pg_restore --no-owner does. Use it when roles of the dumped database
+// do not exist on the test server.
+func WithoutOwnership() DumpOption {
+	return func(o *dumpOptions) { o.noOwner = true }
+}
+
+// WithoutPrivileges skips GRANT and REVOKE statements, like
+// pg_restore --no-privileges does.
+func WithoutPrivileges() DumpOption {
+	return func(o *dumpOptions) { o.noPrivileges = true }
+}
+
+// DumpMigrator replays a plain-format pg_dump output, with both schema
+// and data, on the template database.
+//
+// COPY ... FROM stdin data sections are streamed with the COPY protocol.
+// psql meta-commands, such as \connect, are skipped, so dumps made with
+// pg_dump --create are restored into the template database rather than
+// the dumped one.
+type DumpMigrator struct {
+	fsys    fs.FS
+	path    string
+	hash    string
+	options dumpOptions
+}
+
+// FromDump returns a migrator replaying the dump at path of fsys.
+//
+// The dump contents are hashed eagerly, so that a new dump leads to a new
+// template.
+func FromDump(fsys fs.FS, path string, opts ...DumpOption) (*DumpMigrator, error) {
+	//nolint:exhaustruct // options are disabled by default.
+	options := dumpOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to open dump %q: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to read dump %q: %w", path, err)
+	}
+
+	writeHashField(h, strconv.FormatBool(options.noOwner))
+	writeHashField(h, strconv.FormatBool(options.noPrivileges))
+
+	return &DumpMigrator{fsys: fsys, path: path, hash: hex.EncodeToString(h.Sum(nil)), options: options}, nil
+}
+
+// Hash returns a hash of the dump contents and options.
+func (m *DumpMigrator) Hash() string { return m.hash }
+
+// Migrate replays the dump.
+//
+// Session settings changed by the dump preamble, such as the search path,
+// are reset once the dump is replayed.
+func (m *DumpMigrator) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	f, err := m.fsys.Open(m.path)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to open dump %q: %w", m.path, err)
+	}
+	defer f.Close()
+
+	p := pgdump.NewParser(f)
+
+	for {
+		stmt, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to parse dump %q: %w", m.path, err)
+		}
+
+		if err := m.replay(ctx, conn, stmt); err != nil {
+			return fmt.Errorf("pgxephemeraltest: failed to replay dump %q:%d: %w", m.path, stmt.Line, err)
+		}
+	}
+
+	if _, err := conn.Exec(ctx, "RESET ALL"); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to reset session settings: %w", err)
+	}
+
+	return nil
+}
+
+func (m *DumpMigrator) replay(ctx context.Context, conn *pgx.Conn, stmt pgdump.Statement) error {
+	switch stmt.Kind {
+	case pgdump.KindMeta:
+		return nil
+	case pgdump.KindCopy:
+		if _, err := conn.PgConn().CopyFrom(ctx, stmt.Data, stmt.SQL); err != nil {
+			return fmt.Errorf("copy data: %w", err)
+		}
+
+		return nil
+	case pgdump.KindSQL:
+		if m.options.noOwner && ownerToRe.MatchString(stmt.SQL) ||
+			m.options.noPrivileges && privilegeRe.MatchString(stmt.SQL) {
+			return nil
+		}
+
+		// Statements of dumps have no parameters and run once, there is
+		// no point in preparing them.
+		if _, err := conn.Exec(ctx, stmt.SQL, pgx.QueryExecModeSimpleProtocol); err != nil {
+			return fmt.Errorf("execute statement: %w", err)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unexpected statement kind %d", stmt.Kind)
+	}
+}
@@ -0,0 +1,60 @@
+package migrators_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+	"go.segfaultmedaddy.com/pgxephemeraltest/migrators"
+)
+
+func TestFromDump(t *testing.T) {
+	t.Parallel()
+
+	fsys := os.DirFS("testdata")
+
+	t.Run("it hashes options along with the dump", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		a, err := migrators.FromDump(fsys, "library.sql")
+		require.NoError(t, err)
+
+		b, err := migrators.FromDump(fsys, "library.sql", migrators.WithoutOwnership())
+		require.NoError(t, err)
+
+		// Assert
+		assert.NotEqual(t, a.Hash(), b.Hash())
+	})
+
+	t.Run("it replays schema and data of the dump", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		m, err := migrators.FromDump(
+			fsys,
+			"library.sql",
+			migrators.WithoutOwnership(),
+			migrators.WithoutPrivileges(),
+		)
+		require.NoError(t, err)
+
+		f, err := pgxephemeraltest.NewPoolFactory(t.Context(), testutil.PoolConfig(t), m)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Act
+		pool := f.Pool(t)
+
+		// Assert
+		var name string
+
+		err = pool.QueryRow(t.Context(), "SELECT shout(name) FROM authors WHERE id = 2").Scan(&name)
+		require.NoError(t, err)
+		assert.Equal(t, "BOB; THE BUILDER!", name)
+	})
+}
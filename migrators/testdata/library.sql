@@ -0,0 +1,34 @@
+--
+-- PostgreSQL database dump
+--
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+SET check_function_bodies = false;
+
+CREATE TABLE public.authors (
+    id integer NOT NULL,
+    name text NOT NULL
+);
+
+ALTER TABLE public.authors OWNER TO production_owner;
+
+CREATE FUNCTION public.shout(s text) RETURNS text
+    LANGUAGE sql IMMUTABLE
+    AS $$SELECT upper(s) || '!';$$;
+
+COPY public.authors (id, name) FROM stdin;
+1	Alice
+2	Bob; the builder
+\.
+
+ALTER TABLE ONLY public.authors
+    ADD CONSTRAINT authors_pkey PRIMARY KEY (id);
+
+GRANT SELECT ON TABLE public.authors TO production_reader;
+
+--
+-- PostgreSQL database dump complete
+--
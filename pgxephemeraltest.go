@@ -10,25 +10,35 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
 	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
 )
 
 var (
 	_ Executor = (*pgx.Conn)(nil)
 	_ Executor = (*pgxpool.Pool)(nil)
+	_ Querier  = (pgx.Tx)(nil)
 )
 
 var DefaultCleanupTimeout = time.Second * 15 //nolint:gochecknoglobals
 
 // Executor is an interface common to pgx.Conn and pgxpool.Pool.
 type Executor interface {
+	Querier
+	BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error)
+}
+
+// Querier is an interface common to pgx.Conn, pgx.Tx and pgxpool.Pool.
+//
+// It is accepted by helpers that should work with both PoolFactory pools
+// and TxFactory transactions.
+type Querier interface {
 	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
 	Query(context.Context, string, ...any) (pgx.Rows, error)
 	QueryRow(context.Context, string, ...any) pgx.Row
 	CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error)
 	SendBatch(context.Context, *pgx.Batch) pgx.BatchResults
 	Begin(context.Context) (pgx.Tx, error)
-	BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error)
 }
 
 // FactoryOption is an option to configure PoolFactory and TxFactory.
@@ -40,6 +50,17 @@ func WithCleanupTimeout(timeout time.Duration) FactoryOption {
 	return func(config *factoryOptions) { config.cleanupTimeout = timeout }
 }
 
+// DefaultMaintenanceConns is the default maximum number of maintenance
+// connections of a factory, see WithMaintenanceConns.
+const DefaultMaintenanceConns = dbmanager.DefaultMaintenanceConns
+
+// WithMaintenanceConns sets the maximum number of connections a factory uses
+// to create and drop databases, which also caps the number of concurrent
+// CREATE DATABASE statements issued by parallel tests.
+func WithMaintenanceConns(n int32) FactoryOption {
+	return func(config *factoryOptions) { config.maintenanceConns = n }
+}
+
 // assertNoError is a helper function that asserts that an error is nil.
 func assertNoError(t internaltesting.TB, err error, m ...string) {
 	t.Helper()
@@ -36,8 +36,9 @@ func BenchmarkTx_NewInstance(b *testing.B) {
 // the template database.
 func BenchmarkPool_Template(b *testing.B) {
 	for b.Loop() {
-		_, err := pgxephemeraltest.NewPoolFactory(b.Context(), testutil.PoolConfig(b), testutil.NewKVMigrator())
+		f, err := pgxephemeraltest.NewPoolFactory(b.Context(), testutil.PoolConfig(b), testutil.NewKVMigrator())
 		require.NoError(b, err)
+		f.Close()
 	}
 }
 
@@ -46,6 +47,7 @@ func BenchmarkPool_Template(b *testing.B) {
 func BenchmarkPool_NewInstance(b *testing.B) {
 	f, err := pgxephemeraltest.NewPoolFactory(b.Context(), testutil.PoolConfig(b), testutil.NewKVMigrator())
 	require.NoError(b, err)
+	b.Cleanup(f.Close)
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
@@ -3,6 +3,7 @@ package pgxephemeraltest
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,10 +15,43 @@ import (
 )
 
 type factoryOptions struct {
-	cleanupTimeout time.Duration
+	redactQueryArgs     QueryArgsRedactor
+	cleanupTimeout      time.Duration
+	verboseQueryLog     bool
+	captureChanges      bool
+	staleTemplatePolicy StaleTemplatePolicy
+	templateGC          *TemplateGCPolicy
+	templateLineage     string
+	extensions          []string
+	roles               []RoleSpec
+	rolePrefix          string
+	appConfig           *pgxpool.Config
+	databaseSettings    map[string]string
+	createOptions       CreateDatabaseOptions
+	maintenanceConns    int32
+	maxDatabases        int
+	maxTotalConns       int
+	serverConnBudget    bool
 }
 
-func (p *factoryOptions) defaults() { p.cleanupTimeout = DefaultCleanupTimeout }
+func (p *factoryOptions) defaults() {
+	if p.cleanupTimeout <= 0 {
+		p.cleanupTimeout = DefaultCleanupTimeout
+	}
+
+	if p.maintenanceConns == 0 {
+		p.maintenanceConns = dbmanager.DefaultMaintenanceConns
+	}
+}
+
+// managerOptions returns options of the database manager set by options.
+func (p *factoryOptions) managerOptions() []dbmanager.Option {
+	return []dbmanager.Option{
+		dbmanager.WithDatabaseSettings(p.databaseSettings),
+		dbmanager.WithCreateOptions(p.createOptions),
+		dbmanager.WithMaintenanceConns(p.maintenanceConns),
+	}
+}
 
 const (
 	DatabasePrefix = dbmanager.DatabasePrefix
@@ -41,22 +75,30 @@ type Migrator = dbmanager.Migrator
 // Each created database is prepared with applied migration provided by running
 // provided migrator.
 type PoolFactory struct {
-	m              *dbmanager.DBManager
-	config         *pgxpool.Config
-	template       string
-	cleanupTimeout time.Duration
+	m           *dbmanager.DBManager
+	config      *pgxpool.Config
+	template    string
+	fingerprint string
+	options     factoryOptions
+	budget      *budget
+	// borrowed is set if the maintenance connections are owned by another
+	// factory, e.g., for versions of UpgradeFactory, so Close leaves them
+	// to the owner.
+	borrowed bool
 }
 
 // NewPoolFactory creates a new PoolFactory instance.
 //
 // It initializes a new database, applies migration to it and marks it as
 // a template. The template is copied for each newly created ephemeral database.
+//
+// The factory holds maintenance connections, which are released by Close.
 func NewPoolFactory(
 	ctx context.Context,
 	config *pgxpool.Config,
 	migrator Migrator,
 	opts ...FactoryOption,
-) (*PoolFactory, error) {
+) (_ *PoolFactory, err error) {
 	var options factoryOptions
 	for _, opt := range opts {
 		opt(&options)
@@ -64,22 +106,60 @@ func NewPoolFactory(
 
 	options.defaults()
 
-	m, err := dbmanager.New(ctx, config)
+	m, err := dbmanager.New(ctx, config, options.managerOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize factory: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			m.Close()
+		}
+	}()
+
+	if err := ensureRoles(ctx, m, options); err != nil {
+		return nil, err
+	}
+
+	migrator, err = options.withExtensions(ctx, m, migrator)
 	if err != nil {
 		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize factory: %w", err)
 	}
 
-	template := dbmanager.TemplateName(config.ConnConfig, migrator)
+	migrator = options.withLineage(options.withAppPrivileges(migrator))
+
+	template := dbmanager.TemplateName(config.ConnConfig, migrator, options.createOptions.Identity())
 
 	if err := m.Init(ctx, migrator, template); err != nil {
 		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize factory: %w", err)
 	}
 
+	if err := checkTemplate(ctx, m, migrator, "", template, options.staleTemplatePolicy); err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := m.Fingerprint(ctx, template)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to fingerprint template: %w", err)
+	}
+
+	if err := collectTemplates(ctx, m, options, template); err != nil {
+		return nil, err
+	}
+
+	b, err := newBudget(ctx, m, config, options)
+	if err != nil {
+		return nil, err
+	}
+
 	f := PoolFactory{
-		cleanupTimeout: options.cleanupTimeout,
-		config:         config.Copy(),
-		m:              m,
-		template:       template,
+		config:      config.Copy(),
+		m:           m,
+		template:    template,
+		fingerprint: fingerprint,
+		options:     options,
+		budget:      b,
+		borrowed:    false,
 	}
 
 	return &f, nil
@@ -101,6 +181,18 @@ func NewPoolFactoryFromConnString(
 	return NewPoolFactory(ctx, config, migrator, opts...)
 }
 
+// Close closes maintenance connections of the factory. Pools handed out by
+// the factory are not affected, but no new ones can be created. Databases
+// of tests still running are dropped by their cleanups as usual.
+//
+// Close is a no-op for factories returned by UpgradeFactory.Version, whose
+// connections are closed by UpgradeFactory.Close.
+func (f *PoolFactory) Close() {
+	if !f.borrowed {
+		f.m.Close()
+	}
+}
+
 // Template returns the template name used to create ephemeral databases.
 //
 // It is computed once on PoolFactory creation and stays the same
@@ -116,15 +208,35 @@ func (f *PoolFactory) Template() string { return f.template }
 // Lifetime of the pool is managed by the tb, the pool is closed when
 // the test is done. If a test is failed the database is left intact for debugging,
 // otherwise it is dropped.
+//
+// Queries executed through the pool are buffered and written to the test log
+// if the test fails (or always, see WithVerboseQueryLog).
+//
+// If the factory limits databases or connections (see WithMaxDatabases and
+// WithMaxTotalConns), Pool blocks until pools of finished tests free up
+// the capacity. A test requesting more pools than the limits allow at
+// the same time fails right away.
 func (f *PoolFactory) Pool(tb internaltesting.TB) *pgxpool.Pool {
 	tb.Helper()
 
 	ctx := tb.Context()
+	state := stateFor(tb, f.options)
+
+	// Released after the database is dropped, as cleanups run in reverse.
+	release, err := f.budget.acquire(tb)
+	assertNoError(tb, err)
+	tb.Cleanup(release)
 
-	db, err := f.createDB(ctx)
+	db, err := f.createDB(dbmanager.ContextWithLogf(ctx, tb.Logf))
 	assertNoError(tb, err, "pgxephemeraltest: failed to create ephemeral database")
 
-	pool, err := f.pool(ctx, db)
+	if f.options.captureChanges {
+		config, err := f.installChangeCapture(ctx, db)
+		assertNoError(tb, err, "pgxephemeraltest: failed to prepare ephemeral database")
+		state.addCapture(config)
+	}
+
+	pool, err := f.pool(ctx, db, state)
 	assertNoError(tb, err, "pgxephemeraltest: failed to connect to ephemeral database")
 
 	tb.Logf("pgxephemeraltest: spun up a new ephemeral database for test: %s", db)
@@ -132,7 +244,7 @@ func (f *PoolFactory) Pool(tb internaltesting.TB) *pgxpool.Pool {
 	tb.Cleanup(func() {
 		pool.Close()
 
-		ctx, cancel := context.WithTimeout(context.Background(), f.cleanupTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), f.options.cleanupTimeout)
 		defer cancel()
 
 		// Leave the database intact if the test has failed for debugging
@@ -142,7 +254,8 @@ func (f *PoolFactory) Pool(tb internaltesting.TB) *pgxpool.Pool {
 			return
 		}
 
-		if err := f.m.DropDB(ctx, db); err != nil {
+		// Sessions leaked by the test are terminated and reported.
+		if err := f.m.DropDB(dbmanager.ContextWithLogf(ctx, tb.Logf), db); err != nil {
 			tb.Logf("pgxephemeraltest: failed to drop ephemeral database: %s - %v", db, err)
 		} else {
 			tb.Logf("pgxephemeraltest: dropped ephemeral database: %s", db)
@@ -163,13 +276,25 @@ func (f *PoolFactory) createDB(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("create ephemeral database from template %q: %w", f.template, err)
 	}
 
+	if err := f.grantApp(ctx, db); err != nil {
+		if dropErr := f.m.DropDB(context.WithoutCancel(ctx), db); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+
+		return "", err
+	}
+
 	return db, nil
 }
 
 // pool creates a new pool connected to the db ephemeral database.
-func (f *PoolFactory) pool(ctx context.Context, db string) (*pgxpool.Pool, error) {
-	config := f.config.Copy()
+//
+// Queries executed through the pool are recorded into the state's query log.
+func (f *PoolFactory) pool(ctx context.Context, db string, state *testState) (*pgxpool.Pool, error) {
+	config := f.poolConfig()
 	config.ConnConfig.Database = db
+	f.budget.size(config)
+	config.ConnConfig.Tracer = composeTracer(config.ConnConfig.Tracer, newPoolQueryTracer(state.log))
 
 	p, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
@@ -2,6 +2,7 @@ package pgxephemeraltest_test
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"strconv"
 	"sync"
 	"testing"
@@ -30,6 +31,7 @@ func TestNewPoolFactory(t *testing.T) {
 	// Assert
 	require.NoError(t, err)
 	assert.NotNil(t, f)
+	t.Cleanup(f.Close)
 
 	err = f.Pool(t).Ping(t.Context())
 	require.NoError(t, err)
@@ -48,6 +50,7 @@ func TestNewPoolFactoryFromConnString(t *testing.T) {
 	// Assert
 	require.NoError(t, err)
 	assert.NotNil(t, f)
+	t.Cleanup(f.Close)
 
 	err = f.Pool(t).Ping(t.Context())
 	require.NoError(t, err)
@@ -60,6 +63,7 @@ func TestPoolFactory(t *testing.T) {
 
 	f, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, testutil.NewKVMigrator())
 	require.NoError(t, err)
+	t.Cleanup(f.Close)
 
 	t.Run("it cleans up database on success", func(t *testing.T) {
 		t.Parallel()
@@ -219,6 +223,7 @@ func TestPoolFactory_Parallel(t *testing.T) {
 		testutil.NewKVMigrator(),
 	)
 	require.NoError(t, err)
+	t.Cleanup(f.Close)
 
 	for i := range 5 {
 		t.Run(fmt.Sprintf("pool %d", i), func(t *testing.T) {
@@ -253,6 +258,7 @@ func TestPoolFactory_NoopMigrator(t *testing.T) {
 
 	f, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, testutil.NewNoopMigrator())
 	require.NoError(t, err)
+	t.Cleanup(f.Close)
 
 	// Act
 	p1 := f.Pool(t)
@@ -273,3 +279,70 @@ func TestPoolFactory_NoopMigrator(t *testing.T) {
 		assert.NoError(t, err, "pool should be connected to a valid database")
 	}
 }
+
+func TestPoolFactory_SchemaFingerprint(t *testing.T) {
+	t.Parallel()
+
+	config := testutil.PoolConfig(t)
+
+	t.Run("it fingerprints the template schema", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		hash := "fp-" + strconv.FormatInt(rand.Int64(), 10) // #nosec G404
+		kv := testutil.NewMigrator(testutil.KVSchema, hash+"-kv")
+		sameKV := testutil.NewMigrator(testutil.KVSchema, hash+"-same-kv")
+		noop := testutil.NewMigrator("", hash+"-noop")
+
+		// Act
+		f1, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, kv)
+		require.NoError(t, err)
+		t.Cleanup(f1.Close)
+
+		f2, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, sameKV)
+		require.NoError(t, err)
+		t.Cleanup(f2.Close)
+
+		f3, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, noop)
+		require.NoError(t, err)
+		t.Cleanup(f3.Close)
+
+		// Assert
+		assert.NotEmpty(t, f1.SchemaFingerprint())
+		assert.NotEqual(t, f1.Template(), f2.Template())
+		assert.Equal(t, f1.SchemaFingerprint(), f2.SchemaFingerprint())
+		assert.NotEqual(t, f1.SchemaFingerprint(), f3.SchemaFingerprint())
+	})
+
+	t.Run("it rebuilds a stale template", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		hash := "stale-" + strconv.FormatInt(rand.Int64(), 10) // #nosec G404
+		migrator := testutil.NewMigrator(testutil.KVSchema, hash)
+
+		stale, err := pgxephemeraltest.NewPoolFactory(t.Context(), config, migrator)
+		require.NoError(t, err)
+		t.Cleanup(stale.Close)
+
+		// The migrator changes without changing its hash.
+		migrator.Schema = "CREATE TABLE users (id INT PRIMARY KEY)"
+
+		// Act
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithStaleTemplateCheck(pgxephemeraltest.StaleTemplateRebuild),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Assert
+		assert.Equal(t, stale.Template(), f.Template())
+		assert.NotEqual(t, stale.SchemaFingerprint(), f.SchemaFingerprint())
+
+		_, err = f.Pool(t).Exec(t.Context(), "INSERT INTO users (id) VALUES (1)")
+		require.NoError(t, err)
+	})
+}
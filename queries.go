@@ -0,0 +1,254 @@
+package pgxephemeraltest
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+var (
+	// stringLiteralRe matches single quoted SQL string literals.
+	stringLiteralRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+	// numberLiteralRe matches numeric literals not being part of an identifier
+	// or a positional parameter.
+	numberLiteralRe = regexp.MustCompile(`([^\w$])-?\d+(?:\.\d+)?`)
+
+	// inListRe matches IN lists, so that lists of different length
+	// normalize to the same statement.
+	inListRe = regexp.MustCompile(`(?i)\bin\s*\(\s*(?:\?|\$\d+)(?:\s*,\s*(?:\?|\$\d+))*\s*\)`)
+
+	whitespaceRe = regexp.MustCompile(`\s+`)
+
+	// txControlRe matches transaction control statements issued by pgx itself.
+	txControlRe = regexp.MustCompile(`(?i)^(begin|commit|rollback|savepoint|release)\b`)
+)
+
+// QueryRecorder exposes queries executed by a test for assertions.
+//
+// A QueryRecorder is obtained via Queries.
+type QueryRecorder struct {
+	// tb is the test the recorder was obtained for, which invalid patterns
+	// are reported to.
+	tb  internaltesting.TB
+	log *queryLog
+	// offset is the number of queries to skip, see Reset.
+	offset int
+}
+
+// Queries returns the recorder of queries executed within the tb test through
+// pools and transactions handed out by PoolFactory and TxFactory.
+//
+// Queries issued by the package helpers, e.g., Snapshot, AsRole, Build and
+// fixtures, are not recorded, so that assertions only count queries of
+// the code under test. They are still included into the query log written
+// on test failure.
+//
+// It fails the test if no pool or transaction has been handed out to the test.
+// Transactions of a TxFactory created by NewTxFactory are not recorded, as
+// the factory can't trace queries of a pool it doesn't own, so Queries fails
+// for tests using only them; create the factory with NewTxFactoryFromConnString
+// instead.
+func Queries(tb internaltesting.TB) *QueryRecorder {
+	tb.Helper()
+
+	s, ok := testStates.Load(tb)
+	if !ok {
+		tb.Fatalf(
+			"pgxephemeraltest: no ephemeral pool or transaction recorded for test %s "+
+				"(transactions of NewTxFactory are not recorded, use NewTxFactoryFromConnString)",
+			tb.Name(),
+		)
+
+		return nil
+	}
+
+	//nolint:forcetypeassert // only *testState is stored.
+	log := s.(*testState).log
+
+	return &QueryRecorder{tb: tb, log: log, offset: 0}
+}
+
+// All returns the recorded queries in the order of execution.
+func (r *QueryRecorder) All() []Query {
+	queries := r.log.all()
+	if r.offset > len(queries) {
+		return nil
+	}
+
+	return slices.DeleteFunc(queries[r.offset:], func(q Query) bool { return q.internal })
+}
+
+// Len returns the number of recorded queries.
+func (r *QueryRecorder) Len() int { return len(r.All()) }
+
+// Reset makes the recorder ignore queries executed so far, which is handy
+// to exclude queries issued while arranging the test.
+//
+// The queries are still included into the query log written on test failure.
+func (r *QueryRecorder) Reset() { r.offset = len(r.log.all()) }
+
+// Filter returns the recorded queries satisfying fn.
+func (r *QueryRecorder) Filter(fn func(Query) bool) []Query {
+	return slices.DeleteFunc(r.All(), func(q Query) bool { return !fn(q) })
+}
+
+// Matching returns the recorded queries whose SQL matches the pattern regular
+// expression. It fails the test if the pattern is invalid.
+func (r *QueryRecorder) Matching(pattern string) []Query {
+	r.tb.Helper()
+
+	return r.matching(r.tb, pattern)
+}
+
+// matching is like Matching, but reports an invalid pattern to tb.
+func (r *QueryRecorder) matching(tb internaltesting.TB, pattern string) []Query {
+	tb.Helper()
+
+	re := compilePattern(tb, pattern)
+	if re == nil {
+		return nil
+	}
+
+	return r.Filter(func(q Query) bool { return re.MatchString(q.SQL) })
+}
+
+// Repeated returns normalized statements executed more than maxCount times along
+// with the number of executions.
+//
+// Statements are normalized by stripping literals and collapsing IN lists,
+// so that queries differing only in their arguments are considered the same.
+// Transaction control statements are ignored.
+func (r *QueryRecorder) Repeated(maxCount int) map[string]int {
+	counts := make(map[string]int)
+
+	for _, q := range r.All() {
+		sql := normalizeSQL(q.SQL)
+		if txControlRe.MatchString(sql) {
+			continue
+		}
+
+		counts[sql]++
+	}
+
+	for sql, n := range counts {
+		if n <= maxCount {
+			delete(counts, sql)
+		}
+	}
+
+	return counts
+}
+
+// AssertCount asserts that exactly n queries were recorded.
+func (r *QueryRecorder) AssertCount(tb internaltesting.TB, n int) {
+	tb.Helper()
+
+	if queries := r.All(); len(queries) != n {
+		tb.Errorf("pgxephemeraltest: expected %d queries, got %d:%s", n, len(queries), formatQueries(queries))
+	}
+}
+
+// AssertCountMatching asserts that exactly n recorded queries match the pattern
+// regular expression.
+func (r *QueryRecorder) AssertCountMatching(tb internaltesting.TB, pattern string, n int) {
+	tb.Helper()
+
+	if queries := r.matching(tb, pattern); len(queries) != n {
+		tb.Errorf(
+			"pgxephemeraltest: expected %d queries matching %q, got %d:%s",
+			n, pattern, len(queries), formatQueries(queries),
+		)
+	}
+}
+
+// AssertNoneMatching asserts that none of the recorded queries match the pattern
+// regular expression.
+func (r *QueryRecorder) AssertNoneMatching(tb internaltesting.TB, pattern string) {
+	tb.Helper()
+
+	if queries := r.matching(tb, pattern); len(queries) > 0 {
+		tb.Errorf("pgxephemeraltest: expected no queries matching %q, got %d:%s",
+			pattern, len(queries), formatQueries(queries))
+	}
+}
+
+// AssertEveryMatching asserts that every recorded query matching the pattern
+// regular expression also matches the required regular expression.
+//
+// For instance, to ensure that every query touching the users table filters
+// by tenant:
+//
+//	pgxephemeraltest.Queries(t).AssertEveryMatching(t, `\busers\b`, `\btenant_id\b`)
+func (r *QueryRecorder) AssertEveryMatching(tb internaltesting.TB, pattern, required string) {
+	tb.Helper()
+
+	re := compilePattern(tb, required)
+	if re == nil {
+		return
+	}
+
+	violations := slices.DeleteFunc(r.matching(tb, pattern), func(q Query) bool { return re.MatchString(q.SQL) })
+	if len(violations) > 0 {
+		tb.Errorf("pgxephemeraltest: expected queries matching %q to match %q, got %d violations:%s",
+			pattern, required, len(violations), formatQueries(violations))
+	}
+}
+
+// AssertNoRepeats asserts that no normalized statement was executed more than
+// maxCount times, which usually indicates an N+1 query pattern.
+//
+// See Repeated for details on normalization.
+func (r *QueryRecorder) AssertNoRepeats(tb internaltesting.TB, maxCount int) {
+	tb.Helper()
+
+	repeated := r.Repeated(maxCount)
+	if len(repeated) == 0 {
+		return
+	}
+
+	var b strings.Builder
+
+	for _, sql := range slices.Sorted(maps.Keys(repeated)) {
+		fmt.Fprintf(&b, "\n  %dx %s", repeated[sql], sql)
+	}
+
+	tb.Errorf("pgxephemeraltest: expected statements to be executed at most %d times:%s", maxCount, b.String())
+}
+
+// compilePattern compiles the pattern regular expression, failing the test
+// if it is invalid.
+func compilePattern(tb internaltesting.TB, pattern string) *regexp.Regexp {
+	tb.Helper()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		tb.Fatalf("pgxephemeraltest: invalid query pattern %q: %v", pattern, err)
+		return nil
+	}
+
+	return re
+}
+
+// normalizeSQL strips literals from sql and collapses whitespace.
+func normalizeSQL(sql string) string {
+	sql = stringLiteralRe.ReplaceAllString(sql, "?")
+	sql = numberLiteralRe.ReplaceAllString(" "+sql, "$1?")
+	sql = inListRe.ReplaceAllString(sql, "IN (...)")
+	sql = whitespaceRe.ReplaceAllString(sql, " ")
+
+	return strings.TrimSpace(sql)
+}
+
+func formatQueries(queries []Query) string {
+	var b strings.Builder
+	for i, q := range queries {
+		fmt.Fprintf(&b, "\n  #%d %s", i+1, strings.TrimSpace(q.SQL))
+	}
+
+	return b.String()
+}
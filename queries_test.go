@@ -0,0 +1,204 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/querytag"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT * FROM users WHERE id = 1", "SELECT * FROM users WHERE id = ?"},
+		{"SELECT * FROM users WHERE name = 'O''Brien'", "SELECT * FROM users WHERE name = ?"},
+		{"SELECT * FROM users WHERE id = $1", "SELECT * FROM users WHERE id = $1"},
+		{"SELECT * FROM t2 WHERE id IN (1, 2, 3)", "SELECT * FROM t2 WHERE id IN (...)"},
+		{"SELECT *\n  FROM users\n  WHERE id = 42", "SELECT * FROM users WHERE id = ?"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, normalizeSQL(tt.sql), tt.sql)
+	}
+}
+
+func TestQueryRecorder(t *testing.T) {
+	t.Parallel()
+
+	newRecorder := func(t *testing.T, sqls ...string) *QueryRecorder {
+		log := newQueryLog(nil)
+		for _, sql := range sqls {
+			log.record(Query{SQL: sql, Args: nil, StartedAt: time.Now(), Duration: 0, RowsAffected: 0, Err: nil})
+		}
+
+		return &QueryRecorder{tb: t, log: log, offset: 0}
+	}
+
+	t.Run("it ignores queries before reset", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		r := newRecorder(t, "SELECT 1", "SELECT 2")
+
+		// Act
+		r.Reset()
+		r.log.record(Query{SQL: "SELECT 3", Args: nil, StartedAt: time.Now(), Duration: 0, RowsAffected: 0, Err: nil})
+
+		// Assert
+		require.Len(t, r.All(), 1)
+		assert.Equal(t, "SELECT 3", r.All()[0].SQL)
+	})
+
+	t.Run("it detects repeated statements", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		r := newRecorder(
+			t,
+			"begin",
+			"SELECT * FROM authors",
+			"SELECT * FROM books WHERE author_id = 1",
+			"SELECT * FROM books WHERE author_id = 2",
+			"SELECT * FROM books WHERE author_id = 3",
+			"commit",
+		)
+
+		// Act
+		repeated := r.Repeated(1)
+
+		// Assert
+		assert.Equal(t, map[string]int{"SELECT * FROM books WHERE author_id = ?": 3}, repeated)
+	})
+
+	t.Run("it reports violations of required patterns", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var (
+			ctrl = gomock.NewController(t)
+			tt   = internaltesting.NewMockTB(ctrl)
+			r    = newRecorder(
+				t,
+				"SELECT * FROM users WHERE tenant_id = $1",
+				"SELECT * FROM users WHERE id = $1",
+				"SELECT * FROM orders",
+			)
+		)
+
+		tt.EXPECT().Helper().AnyTimes()
+		tt.EXPECT().Errorf(gomock.Any(), gomock.Any()).Times(1)
+
+		// Act & Assert
+		r.AssertEveryMatching(tt, `\busers\b`, `\btenant_id\b`)
+		r.AssertCount(t, 3)
+		r.AssertCountMatching(t, `\busers\b`, 2)
+		r.AssertNoneMatching(t, `\bDELETE\b`)
+	})
+
+	t.Run("it fails the test on invalid patterns", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var (
+			ctrl = gomock.NewController(t)
+			tt   = internaltesting.NewMockTB(ctrl)
+			r    = newRecorder(t, "SELECT 1")
+		)
+
+		tt.EXPECT().Helper().AnyTimes()
+		tt.EXPECT().
+			Fatalf("pgxephemeraltest: invalid query pattern %q: %v", gomock.Any(), gomock.Any()).
+			Times(2)
+
+		// Act & Assert
+		r.AssertCountMatching(tt, "(", 0)
+		r.AssertEveryMatching(tt, "SELECT", "[")
+	})
+
+	t.Run("it ignores internal queries", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		log := newQueryLog(nil)
+		tracer := newPoolQueryTracer(log)
+
+		trace := func(ctx context.Context, sql string) {
+			ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: sql, Args: nil})
+			tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.CommandTag{}, Err: nil})
+		}
+
+		// Act
+		trace(querytag.Internal(t.Context()), "SELECT * FROM pg_class")
+		trace(t.Context(), "SELECT * FROM kv")
+
+		// Assert
+		r := &QueryRecorder{tb: t, log: log, offset: 0}
+		require.Len(t, r.All(), 1)
+		assert.Equal(t, "SELECT * FROM kv", r.All()[0].SQL)
+		assert.Len(t, log.all(), 2, "internal queries must stay in the query log")
+	})
+}
+
+func TestQueries(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewPoolFactory(t.Context(), testutil.PoolConfig(t), testutil.NewKVMigrator())
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	t.Run("it records queries executed through the pool", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		pool := f.Pool(t)
+
+		// Act
+		for _, key := range []string{"a", "b"} {
+			_, err := pool.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ($1, $2)", key, key)
+			require.NoError(t, err)
+		}
+
+		rows, err := pool.Query(t.Context(), "SELECT * FROM kv")
+		require.NoError(t, err)
+		rows.Close()
+
+		// Assert
+		q := Queries(t)
+		q.AssertCountMatching(t, `^INSERT INTO kv`, 2)
+		q.AssertNoRepeats(t, 2)
+
+		selects := q.Matching(`^SELECT \* FROM kv`)
+		require.Len(t, selects, 1)
+		assert.Equal(t, int64(2), selects[0].RowsAffected)
+	})
+
+	t.Run("it doesn't record queries of helpers", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		pool := f.Pool(t)
+
+		_, err := pool.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ('a', 'a')")
+		require.NoError(t, err)
+
+		// Act
+		TakeSnapshot(t, pool, "kv")
+		WithSettings(t, pool, map[string]string{"app.tenant_id": "1"})
+
+		// Assert
+		Queries(t).AssertCount(t, 1)
+	})
+}
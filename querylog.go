@@ -0,0 +1,267 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/querytag"
+)
+
+var (
+	_ pgx.QueryTracer = (*queryTracer)(nil)
+	_ pgx.BatchTracer = (*queryTracer)(nil)
+)
+
+// RedactedQueryArg is the placeholder used by RedactAllQueryArgs in place
+// of the actual query arguments.
+const RedactedQueryArg = "[REDACTED]"
+
+// QueryArgsRedactor rewrites query arguments before they are stored
+// in the per-test query log.
+//
+// It receives a copy of the arguments, so it is safe to modify the slice
+// in place.
+type QueryArgsRedactor func(sql string, args []any) []any
+
+// RedactAllQueryArgs is a QueryArgsRedactor that replaces every query
+// argument with RedactedQueryArg.
+func RedactAllQueryArgs(_ string, args []any) []any {
+	for i := range args {
+		args[i] = RedactedQueryArg
+	}
+
+	return args
+}
+
+// WithVerboseQueryLog makes factories flush the per-test query log
+// regardless of the test outcome. By default the log is flushed only
+// if the test has failed.
+func WithVerboseQueryLog() FactoryOption {
+	return func(config *factoryOptions) { config.verboseQueryLog = true }
+}
+
+// WithQueryArgsRedactor sets the redactor applied to query arguments before
+// they are stored in the per-test query log.
+func WithQueryArgsRedactor(redactor QueryArgsRedactor) FactoryOption {
+	return func(config *factoryOptions) { config.redactQueryArgs = redactor }
+}
+
+// Query is a single SQL statement executed within a test.
+type Query struct {
+	// SQL is the statement text as sent to the server.
+	SQL string
+
+	// Args are the statement arguments, possibly redacted.
+	Args []any
+
+	// StartedAt is the time the statement was sent.
+	StartedAt time.Time
+
+	// Duration is the time it took to execute the statement.
+	Duration time.Duration
+
+	// RowsAffected is the number of rows returned or affected by
+	// the statement as reported by the server.
+	RowsAffected int64
+
+	// Err is the error returned by the statement, if any.
+	Err error
+
+	// internal reports whether the statement was issued by the library
+	// itself, e.g., by Snapshot, rather than by the code under test.
+	internal bool
+}
+
+// queryLog buffers queries executed within a single test.
+type queryLog struct {
+	redact  QueryArgsRedactor
+	queries []Query
+	mu      sync.Mutex
+}
+
+func newQueryLog(redact QueryArgsRedactor) *queryLog {
+	return &queryLog{redact: redact, queries: nil, mu: sync.Mutex{}}
+}
+
+func (l *queryLog) record(q Query) {
+	if len(q.Args) > 0 {
+		q.Args = append([]any(nil), q.Args...)
+		if l.redact != nil {
+			q.Args = l.redact(q.SQL, q.Args)
+		}
+	}
+
+	l.mu.Lock()
+	l.queries = append(l.queries, q)
+	l.mu.Unlock()
+}
+
+// all returns a copy of the recorded queries.
+func (l *queryLog) all() []Query {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Query(nil), l.queries...)
+}
+
+// flush writes the recorded queries to the test log.
+func (l *queryLog) flush(tb internaltesting.TB) {
+	queries := l.all()
+	if len(queries) == 0 {
+		return
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "pgxephemeraltest: executed queries (%d):", len(queries))
+
+	for i, q := range queries {
+		fmt.Fprintf(&b, "\n  #%d [%s, %d rows] %s", i+1, q.Duration, q.RowsAffected, strings.TrimSpace(q.SQL))
+
+		if len(q.Args) > 0 {
+			fmt.Fprintf(&b, "\n      args: %v", q.Args)
+		}
+
+		if q.Err != nil {
+			fmt.Fprintf(&b, "\n      error: %v", q.Err)
+		}
+	}
+
+	tb.Logf("%s", b.String())
+}
+
+// queryTracer is a pgx.QueryTracer that records executed queries
+// into the query log resolved from the connection.
+type queryTracer struct {
+	// resolve returns the query log for conn, or nil if queries
+	// on conn should not be recorded.
+	resolve func(conn *pgx.Conn) *queryLog
+}
+
+// newPoolQueryTracer returns a tracer recording all queries into log.
+func newPoolQueryTracer(log *queryLog) *queryTracer {
+	return &queryTracer{resolve: func(*pgx.Conn) *queryLog { return log }}
+}
+
+type queryTracerKey struct{}
+
+func (t *queryTracer) TraceQueryStart(
+	ctx context.Context,
+	conn *pgx.Conn,
+	data pgx.TraceQueryStartData,
+) context.Context {
+	if t.resolve(conn) == nil {
+		return ctx
+	}
+
+	//nolint:exhaustruct // the rest is filled in on query end.
+	return context.WithValue(ctx, queryTracerKey{}, Query{
+		SQL:       data.SQL,
+		Args:      data.Args,
+		StartedAt: time.Now(),
+		internal:  querytag.IsInternal(ctx),
+	})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	log := t.resolve(conn)
+	if log == nil {
+		return
+	}
+
+	q, ok := ctx.Value(queryTracerKey{}).(Query)
+	if !ok {
+		return
+	}
+
+	q.Duration = time.Since(q.StartedAt)
+	q.RowsAffected = data.CommandTag.RowsAffected()
+	q.Err = data.Err
+
+	log.record(q)
+}
+
+func (t *queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return ctx
+}
+
+func (t *queryTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	log := t.resolve(conn)
+	if log == nil {
+		return
+	}
+
+	// Batched queries are sent in a single round trip, so there is no
+	// meaningful per-query duration.
+	//nolint:exhaustruct // duration is unknown for batched queries.
+	log.record(Query{
+		SQL:          data.SQL,
+		Args:         data.Args,
+		StartedAt:    time.Now(),
+		RowsAffected: data.CommandTag.RowsAffected(),
+		Err:          data.Err,
+		internal:     querytag.IsInternal(ctx),
+	})
+}
+
+func (t *queryTracer) TraceBatchEnd(context.Context, *pgx.Conn, pgx.TraceBatchEndData) {}
+
+// connQueryTracer routes queries to per-test logs by connection.
+//
+// It is used by TxFactory, where a single pool serves transactions
+// of many tests at once.
+type connQueryTracer struct {
+	logs map[*pgx.Conn]*queryLog
+	*queryTracer
+
+	mu sync.RWMutex
+}
+
+func newConnQueryTracer() *connQueryTracer {
+	t := connQueryTracer{logs: make(map[*pgx.Conn]*queryLog), queryTracer: nil, mu: sync.RWMutex{}}
+	t.queryTracer = &queryTracer{resolve: t.lookup}
+
+	return &t
+}
+
+func (t *connQueryTracer) lookup(conn *pgx.Conn) *queryLog {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.logs[conn]
+}
+
+// attach routes queries executed on conn to log until the returned
+// function is called.
+func (t *connQueryTracer) attach(conn *pgx.Conn, log *queryLog) func() {
+	t.mu.Lock()
+	t.logs[conn] = log
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		// The connection might have been handed over to another test
+		// by the time we detach, so make sure not to drop its log.
+		if t.logs[conn] == log {
+			delete(t.logs, conn)
+		}
+	}
+}
+
+// composeTracer combines the user supplied tracer with our own.
+func composeTracer(user pgx.QueryTracer, own pgx.QueryTracer) pgx.QueryTracer {
+	if user == nil {
+		return own
+	}
+
+	return multitracer.New(user, own)
+}
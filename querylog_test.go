@@ -0,0 +1,162 @@
+package pgxephemeraltest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestQueryLog(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it redacts arguments without touching the caller's slice", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		log := newQueryLog(RedactAllQueryArgs)
+		args := []any{"secret", 42}
+
+		// Act
+		log.record(Query{
+			SQL:          "SELECT $1, $2",
+			Args:         args,
+			StartedAt:    time.Now(),
+			Duration:     0,
+			RowsAffected: 0,
+			Err:          nil,
+		})
+
+		// Assert
+		queries := log.all()
+		require.Len(t, queries, 1)
+		assert.Equal(t, []any{RedactedQueryArg, RedactedQueryArg}, queries[0].Args)
+		assert.Equal(t, []any{"secret", 42}, args)
+	})
+
+	t.Run("it flushes recorded queries in a single log entry", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var (
+			ctrl   = gomock.NewController(t)
+			tt     = internaltesting.NewMockTB(ctrl)
+			output string
+		)
+
+		tt.EXPECT().Logf("%s", gomock.Any()).Times(1).Do(func(_ string, args ...any) {
+			output, _ = args[0].(string)
+		})
+
+		log := newQueryLog(nil)
+		log.record(Query{
+			SQL:          "SELECT 1",
+			Args:         nil,
+			StartedAt:    time.Now(),
+			Duration:     time.Millisecond,
+			RowsAffected: 0,
+			Err:          nil,
+		})
+		log.record(Query{
+			SQL:          "INSERT INTO kv VALUES ($1, $2)",
+			Args:         []any{"k", "v"},
+			StartedAt:    time.Now(),
+			Duration:     time.Millisecond,
+			RowsAffected: 0,
+			Err:          errors.New("boom"),
+		})
+
+		// Act
+		log.flush(tt)
+
+		// Assert
+		assert.Contains(t, output, "executed queries (2)")
+		assert.Contains(t, output, "#1 [1ms, 0 rows] SELECT 1")
+		assert.Contains(t, output, "#2 [1ms, 0 rows] INSERT INTO kv VALUES ($1, $2)")
+		assert.Contains(t, output, "args: [k v]")
+		assert.Contains(t, output, "error: boom")
+	})
+}
+
+func TestPoolFactory_QueryLog(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewPoolFactory(t.Context(), testutil.PoolConfig(t), testutil.NewKVMigrator())
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	t.Run("it flushes the query log on failure", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var (
+			cleanups []func()
+			logs     []string
+			ctrl     = gomock.NewController(t)
+			tt       = internaltesting.NewMockTB(ctrl)
+		)
+
+		tt.EXPECT().Context().AnyTimes().Return(t.Context())
+		tt.EXPECT().Cleanup(gomock.Any()).AnyTimes().Do(func(f func()) {
+			cleanups = append(cleanups, f)
+		})
+		tt.EXPECT().Helper().AnyTimes()
+		tt.EXPECT().Logf(gomock.Any(), gomock.Any()).AnyTimes().Do(func(format string, args ...any) {
+			if format == "%s" {
+				logs = append(logs, args[0].(string)) //nolint:forcetypeassert // flush always logs a string.
+			}
+		})
+		tt.EXPECT().Failed().AnyTimes().Return(true)
+
+		pool := f.Pool(tt)
+
+		// Act
+		_, err := pool.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ($1, $2)", "foo", "bar")
+		require.NoError(t, err)
+
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+
+		// Assert
+		require.Len(t, logs, 1)
+		assert.Contains(t, logs[0], "INSERT INTO kv (key, value) VALUES ($1, $2)")
+		assert.Contains(t, logs[0], "args: [foo bar]")
+	})
+
+	t.Run("it keeps the query log silent on success", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		var (
+			cleanups []func()
+			ctrl     = gomock.NewController(t)
+			tt       = internaltesting.NewMockTB(ctrl)
+		)
+
+		tt.EXPECT().Context().AnyTimes().Return(t.Context())
+		tt.EXPECT().Cleanup(gomock.Any()).AnyTimes().Do(func(f func()) {
+			cleanups = append(cleanups, f)
+		})
+		tt.EXPECT().Helper().AnyTimes()
+		tt.EXPECT().Logf(gomock.Not("%s"), gomock.Any()).AnyTimes()
+		tt.EXPECT().Failed().AnyTimes().Return(false)
+
+		pool := f.Pool(tt)
+
+		// Act
+		_, err := pool.Exec(t.Context(), "SELECT 1")
+		require.NoError(t, err)
+
+		// Assert
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+)
+
+// RoleSpec describes a cluster role required by migrations or tests, e.g.,
+// a role GRANTs are given to or row-level security policies refer to.
+type RoleSpec = dbmanager.Role
+
+// WithRoles makes the factory create the roles before building the template,
+// unless they exist.
+//
+// Roles are cluster-global, so they are provisioned under an advisory lock
+// to keep parallel template builds from racing on CREATE ROLE. Created roles
+// are marked as managed, and can be dropped with the drop-roles CLI command
+// once no database refers to them. Existing roles not created by the factory
+// are left intact.
+func WithRoles(roles ...RoleSpec) FactoryOption {
+	return func(config *factoryOptions) { config.roles = append(config.roles, roles...) }
+}
+
+// WithRolePrefix namespaces the names of roles set by WithRoles with
+// the prefix, e.g., to keep test roles apart from real roles on a shared
+// server. Memberships in roles of the same set are prefixed too.
+//
+// Migrations and tests refer to roles by the prefixed names, see
+// PoolFactory.Role.
+func WithRolePrefix(prefix string) FactoryOption {
+	return func(config *factoryOptions) { config.rolePrefix = prefix }
+}
+
+// Role returns the name of the role set by WithRoles, prefixed as set
+// by WithRolePrefix.
+func (f *PoolFactory) Role(name string) string { return f.options.rolePrefix + name }
+
+// ensureRoles creates the roles set by options.
+func ensureRoles(ctx context.Context, m *dbmanager.DBManager, options factoryOptions) error {
+	if len(options.roles) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(options.roles))
+	for i, role := range options.roles {
+		names[i] = role.Name
+	}
+
+	roles := make([]RoleSpec, len(options.roles))
+	for i, role := range options.roles {
+		role.Name = options.rolePrefix + role.Name
+		role.MemberOf = slices.Clone(role.MemberOf)
+
+		for j, parent := range role.MemberOf {
+			if slices.Contains(names, parent) {
+				role.MemberOf[j] = options.rolePrefix + parent
+			}
+		}
+
+		roles[i] = role
+	}
+
+	if err := m.EnsureRoles(ctx, roles); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to provision roles: %w", err)
+	}
+
+	return nil
+}
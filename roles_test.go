@@ -0,0 +1,73 @@
+package pgxephemeraltest_test
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestWithRoles(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it provisions roles before running the migrator", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		prefix := "it_" + strconv.FormatUint(rand.Uint64(), 36) + "_" // #nosec G404
+		migrator := testutil.NewMigrator(`
+CREATE TABLE notes (id INT PRIMARY KEY, owner TEXT NOT NULL);
+ALTER TABLE notes ENABLE ROW LEVEL SECURITY;
+GRANT SELECT ON notes TO `+prefix+`app_user;
+CREATE POLICY own_notes ON notes TO `+prefix+`app_user USING (owner = current_user);
+INSERT INTO notes (id, owner) VALUES (1, '`+prefix+`reader'), (2, 'someone');`, "roles-"+prefix)
+
+		config := testutil.PoolConfig(t)
+
+		// Act
+		f, err := pgxephemeraltest.NewPoolFactory(
+			t.Context(),
+			config,
+			migrator,
+			pgxephemeraltest.WithRolePrefix(prefix),
+			pgxephemeraltest.WithRoles(
+				pgxephemeraltest.RoleSpec{Name: "app_user"},
+				pgxephemeraltest.RoleSpec{Name: "reader", MemberOf: []string{"app_user"}},
+			),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+		testutil.DropOnCleanup(t, config, []string{f.Template()}, f.Role("reader"), f.Role("app_user"))
+
+		// Assert
+		assert.Equal(t, prefix+"reader", f.Role("reader"))
+
+		tx, err := f.Pool(t).Begin(t.Context())
+		require.NoError(t, err)
+
+		defer func() { _ = tx.Rollback(t.Context()) }()
+
+		_, err = tx.Exec(t.Context(), "SET LOCAL ROLE "+f.Role("reader"))
+		require.NoError(t, err)
+
+		var ids []int
+
+		rows, err := tx.Query(t.Context(), "SELECT id FROM notes")
+		require.NoError(t, err)
+
+		for rows.Next() {
+			var id int
+			require.NoError(t, rows.Scan(&id))
+
+			ids = append(ids, id)
+		}
+
+		require.NoError(t, rows.Err())
+		assert.Equal(t, []int{1}, ids)
+	})
+}
@@ -0,0 +1,88 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/querytag"
+)
+
+// AsRole returns a transaction begun on q with the current role switched
+// to role, e.g., to exercise row-level security policies the way a request
+// of the application does.
+//
+// The role is set with SET LOCAL ROLE, so it is scoped to the transaction,
+// which is rolled back on test cleanup. If q is a transaction, a nested
+// transaction is begun, and the role is reset once it is rolled back.
+// Helpers can be nested:
+//
+//	tx := pgxephemeraltest.AsRole(t, pgxephemeraltest.WithSettings(t, pool, map[string]string{
+//		"app.tenant_id": "42",
+//	}), "app_user")
+//
+// Only statements run on the returned transaction use the role. Code under
+// test beginning its own transactions on the pool runs as the pool role, so
+// to test it as another role, connect as that role, see WithAppConfig.
+//
+// The transaction is rolled back within the cleanup timeout of the factory
+// the test got a pool or transaction from, see WithCleanupTimeout, or
+// DefaultCleanupTimeout.
+func AsRole(tb internaltesting.TB, q Querier, role string) pgx.Tx {
+	tb.Helper()
+
+	tx := scopedTx(tb, q)
+
+	_, err := tx.Exec(querytag.Internal(tb.Context()), "SET LOCAL ROLE "+pgx.Identifier{role}.Sanitize())
+	assertNoError(tb, err, "pgxephemeraltest: failed to set role")
+
+	return tx
+}
+
+// WithSettings returns a transaction begun on q with the configuration
+// parameters set, e.g., custom parameters such as app.tenant_id read by
+// row-level security policies with current_setting.
+//
+// Parameters are set with set_config(name, value, true), so they are scoped
+// to the transaction, which is rolled back on test cleanup. See AsRole
+// for nesting.
+func WithSettings(tb internaltesting.TB, q Querier, settings map[string]string) pgx.Tx {
+	tb.Helper()
+
+	tx := scopedTx(tb, q)
+	ctx := querytag.Internal(tb.Context())
+
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		_, err := tx.Exec(ctx, "SELECT set_config($1, $2, true)", name, settings[name])
+		assertNoError(tb, err, "pgxephemeraltest: failed to set "+name)
+	}
+
+	return tx
+}
+
+// scopedTx begins a transaction on q rolled back on test cleanup.
+func scopedTx(tb internaltesting.TB, q Querier) pgx.Tx {
+	tb.Helper()
+
+	tx, err := q.Begin(querytag.Internal(tb.Context()))
+	assertNoError(tb, err, "pgxephemeraltest: failed to start transaction")
+
+	timeout := cleanupTimeoutFor(tb)
+
+	tb.Cleanup(func() {
+		// The test context is canceled by the time cleanup runs.
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := tx.Rollback(querytag.Internal(ctx))
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			tb.Logf("pgxephemeraltest: failed to roll back scoped transaction: %v", err)
+		}
+	})
+
+	return tx
+}
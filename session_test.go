@@ -0,0 +1,98 @@
+package pgxephemeraltest_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestSessionHelpers(t *testing.T) {
+	t.Parallel()
+
+	prefix := "it_" + strconv.FormatUint(rand.Uint64(), 36) + "_" // #nosec G404
+	migrator := testutil.NewMigrator(`
+CREATE TABLE documents (id INT PRIMARY KEY, tenant_id INT NOT NULL);
+ALTER TABLE documents ENABLE ROW LEVEL SECURITY;
+GRANT SELECT ON documents TO `+prefix+`tenant;
+CREATE POLICY tenant_documents ON documents TO `+prefix+`tenant
+  USING (tenant_id = current_setting('app.tenant_id')::INT);
+INSERT INTO documents (id, tenant_id) VALUES (1, 1), (2, 2), (3, 2);`, "session-"+prefix)
+
+	config := testutil.PoolConfig(t)
+
+	f, err := pgxephemeraltest.NewPoolFactory(
+		t.Context(),
+		config,
+		migrator,
+		pgxephemeraltest.WithRolePrefix(prefix),
+		pgxephemeraltest.WithRoles(pgxephemeraltest.RoleSpec{Name: "tenant"}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+	testutil.DropOnCleanup(t, config, []string{f.Template()}, f.Role("tenant"))
+
+	t.Run("it scopes the role and settings to a transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		pool := f.Pool(t)
+
+		// Act
+		tx := pgxephemeraltest.AsRole(t, pgxephemeraltest.WithSettings(t, pool, map[string]string{
+			"app.tenant_id": "2",
+		}), f.Role("tenant"))
+
+		// Assert
+		var count int
+
+		err := tx.QueryRow(t.Context(), "SELECT count(*) FROM documents").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		err = pool.QueryRow(t.Context(), "SELECT count(*) FROM documents").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 3, count, "other connections are not affected")
+	})
+
+	t.Run("it resets the role of a nested transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		pool := f.Pool(t)
+
+		outer, err := pool.Begin(t.Context())
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = outer.Rollback(context.Background()) })
+
+		var user string
+
+		err = outer.QueryRow(t.Context(), "SELECT current_user").Scan(&user)
+		require.NoError(t, err)
+
+		// Act
+		tx := pgxephemeraltest.AsRole(t, outer, f.Role("tenant"))
+
+		var role string
+
+		err = tx.QueryRow(t.Context(), "SELECT current_user").Scan(&role)
+		require.NoError(t, err)
+
+		require.NoError(t, tx.Rollback(t.Context()))
+
+		// Assert
+		var after string
+
+		err = outer.QueryRow(t.Context(), "SELECT current_user").Scan(&after)
+		require.NoError(t, err)
+		assert.Equal(t, f.Role("tenant"), role)
+		assert.Equal(t, user, after)
+	})
+}
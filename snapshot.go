@@ -0,0 +1,404 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/querytag"
+)
+
+// DataSnapshot holds contents of a set of tables captured by Snapshot.
+type DataSnapshot struct {
+	// Tables are the captured tables keyed by table name. Tables outside
+	// of the public schema are keyed by their qualified name.
+	Tables map[string]*TableSnapshot
+}
+
+// TableSnapshot holds contents of a single table.
+type TableSnapshot struct {
+	// Name is the table name, qualified with the schema unless it is public.
+	Name string
+
+	// Columns are the table columns in their definition order.
+	Columns []string
+
+	// ColumnTypes are the column types as formatted by Postgres,
+	// in the order of Columns.
+	ColumnTypes []string
+
+	// PrimaryKey are the primary key columns, empty if the table
+	// has no primary key.
+	PrimaryKey []string
+
+	// Rows are the table rows ordered by primary key, or by their contents
+	// if the table has no primary key.
+	Rows []Row
+}
+
+// Snapshot captures contents of the given tables, or of all user tables if
+// none are given.
+//
+// It accepts pools, connections and transactions, so it works with both
+// PoolFactory and TxFactory.
+//
+// Table names may be schema-qualified and are resolved using the current
+// search path. Rows are ordered by primary key. Rows of tables without
+// a primary key are ordered by their contents, so the order is stable
+// regardless of the physical order of rows and of column types.
+func Snapshot(ctx context.Context, q Querier, tables ...string) (*DataSnapshot, error) {
+	ctx = querytag.Internal(ctx)
+
+	resolved, err := catalog.ResolveTables(ctx, q, tables...)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to resolve tables: %w", err)
+	}
+
+	s := DataSnapshot{Tables: make(map[string]*TableSnapshot, len(resolved))}
+
+	for _, t := range resolved {
+		ts, err := snapshotTable(ctx, q, t)
+		if err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to snapshot table %s: %w", t.Key(), err)
+		}
+
+		s.Tables[ts.Name] = ts
+	}
+
+	return &s, nil
+}
+
+// TakeSnapshot is like Snapshot, but fails the test on error.
+func TakeSnapshot(tb internaltesting.TB, q Querier, tables ...string) *DataSnapshot {
+	tb.Helper()
+
+	s, err := Snapshot(tb.Context(), q, tables...)
+	assertNoError(tb, err)
+
+	return s
+}
+
+func snapshotTable(ctx context.Context, q Querier, t catalog.Table) (*TableSnapshot, error) {
+	cols, err := catalog.Columns(ctx, q, t)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	pk, err := catalog.PrimaryKey(ctx, q, t)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	// Ordering by the first column is unstable for duplicate values and fails
+	// for types without ordering, e.g., json, so the whole row is compared
+	// instead, independently of the database collation. The row is referenced
+	// as t.*, as t alone resolves to the column of tables having one named t.
+	orderBy := `to_jsonb(t.*)::text COLLATE "C"`
+	if len(pk) > 0 {
+		orderBy = catalog.QuoteIdentifiers(pk)
+	}
+
+	rows, err := q.Query(ctx, strings.Join([]string{
+		"SELECT to_jsonb(t.*)::text FROM",
+		t.Identifier().Sanitize(),
+		"t ORDER BY",
+		orderBy,
+	}, " "))
+	if err != nil {
+		return nil, fmt.Errorf("query rows: %w", err)
+	}
+
+	raw, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("query rows: %w", err)
+	}
+
+	ts := TableSnapshot{
+		Name:        t.Key(),
+		Columns:     make([]string, len(cols)),
+		ColumnTypes: make([]string, len(cols)),
+		PrimaryKey:  pk,
+		Rows:        make([]Row, len(raw)),
+	}
+
+	for i, c := range cols {
+		ts.Columns[i] = c.Name
+		ts.ColumnTypes[i] = c.Type
+	}
+
+	for i := range raw {
+		if ts.Rows[i], err = decodeRow(&raw[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ts, nil
+}
+
+// DiffOption configures Diff.
+type DiffOption func(*diffOptions)
+
+type diffOptions struct {
+	// ignored holds ignored column names, either bare (applies to
+	// all tables) or qualified with a table name.
+	ignored map[string]struct{}
+}
+
+// IgnoreColumns excludes volatile columns, such as timestamps, from comparison.
+//
+// Column names may be qualified with a table name, e.g., "users.updated_at",
+// otherwise they are ignored in all tables.
+func IgnoreColumns(columns ...string) DiffOption {
+	return func(o *diffOptions) {
+		for _, c := range columns {
+			o.ignored[c] = struct{}{}
+		}
+	}
+}
+
+func (o diffOptions) isIgnored(table, column string) bool {
+	_, bare := o.ignored[column]
+	_, qualified := o.ignored[table+"."+column]
+
+	return bare || qualified
+}
+
+// SnapshotDiff is the difference between two snapshots.
+type SnapshotDiff struct {
+	// Tables are tables with differences, ordered by name.
+	Tables []TableDiff
+}
+
+// TableDiff is the difference between two snapshots of a single table.
+type TableDiff struct {
+	Table   string
+	Added   []Row
+	Removed []Row
+	Changed []ChangedRow
+}
+
+// ChangedRow is a row present in both snapshots with different contents.
+type ChangedRow struct {
+	// Key is the primary key of the row formatted as column=value pairs.
+	Key string
+	Old Row
+	New Row
+	// Columns are names of the changed columns.
+	Columns []string
+}
+
+// Diff reports rows added, removed and changed between the a and b snapshots.
+//
+// Rows are matched by primary key. Rows of tables without a primary key are
+// matched by their entire contents, and thus can only be added or removed.
+func Diff(a, b *DataSnapshot, opts ...DiffOption) *SnapshotDiff {
+	options := diffOptions{ignored: make(map[string]struct{})}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	names := slices.Sorted(maps.Keys(a.Tables))
+	for name := range b.Tables {
+		if _, ok := a.Tables[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	var d SnapshotDiff
+
+	for _, name := range names {
+		if td := diffTable(name, a.Tables[name], b.Tables[name], options); td != nil {
+			d.Tables = append(d.Tables, *td)
+		}
+	}
+
+	return &d
+}
+
+func diffTable(name string, a, b *TableSnapshot, options diffOptions) *TableDiff {
+	var pk []string
+	if a != nil {
+		pk = a.PrimaryKey
+	} else if b != nil {
+		pk = b.PrimaryKey
+	}
+
+	keyed := func(s *TableSnapshot) ([]string, map[string]Row) {
+		if s == nil {
+			return nil, nil
+		}
+
+		keys := make([]string, 0, len(s.Rows))
+		m := make(map[string]Row, len(s.Rows))
+
+		for _, row := range s.Rows {
+			row = stripColumns(name, row, options)
+			key := rowKey(row, pk)
+
+			// Disambiguate duplicate rows of tables without a primary key.
+			for i := 2; ; i++ {
+				if _, ok := m[key]; !ok {
+					break
+				}
+
+				key = fmt.Sprintf("%s#%d", rowKey(row, pk), i)
+			}
+
+			keys = append(keys, key)
+			m[key] = row
+		}
+
+		return keys, m
+	}
+
+	aKeys, aRows := keyed(a)
+	bKeys, bRows := keyed(b)
+
+	td := TableDiff{Table: name, Added: nil, Removed: nil, Changed: nil}
+
+	for _, key := range aKeys {
+		newRow, ok := bRows[key]
+		if !ok {
+			td.Removed = append(td.Removed, aRows[key])
+			continue
+		}
+
+		if cols := changedColumns(aRows[key], newRow); len(cols) > 0 {
+			td.Changed = append(td.Changed, ChangedRow{Key: key, Old: aRows[key], New: newRow, Columns: cols})
+		}
+	}
+
+	for _, key := range bKeys {
+		if _, ok := aRows[key]; !ok {
+			td.Added = append(td.Added, bRows[key])
+		}
+	}
+
+	if len(td.Added) == 0 && len(td.Removed) == 0 && len(td.Changed) == 0 {
+		return nil
+	}
+
+	return &td
+}
+
+// Empty reports whether the snapshots are equal.
+func (d *SnapshotDiff) Empty() bool { return len(d.Tables) == 0 }
+
+// String returns a human-readable representation of the difference.
+func (d *SnapshotDiff) String() string {
+	var b strings.Builder
+
+	for i, td := range d.Tables {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "table %s:", td.Table)
+
+		for _, row := range td.Removed {
+			fmt.Fprintf(&b, "\n  - %s", formatRow(row))
+		}
+
+		for _, row := range td.Added {
+			fmt.Fprintf(&b, "\n  + %s", formatRow(row))
+		}
+
+		for _, c := range td.Changed {
+			fmt.Fprintf(&b, "\n  ~ %s:", c.Key)
+
+			for _, col := range c.Columns {
+				fmt.Fprintf(&b, "\n      %s: %s -> %s", col, formatValue(c.Old[col]), formatValue(c.New[col]))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// AssertNoDiff asserts that the a and b snapshots are equal, reporting
+// the difference otherwise.
+func AssertNoDiff(tb internaltesting.TB, a, b *DataSnapshot, opts ...DiffOption) {
+	tb.Helper()
+
+	if d := Diff(a, b, opts...); !d.Empty() {
+		tb.Errorf("pgxephemeraltest: snapshots differ:\n%s", d)
+	}
+}
+
+func stripColumns(table string, row Row, options diffOptions) Row {
+	if len(options.ignored) == 0 {
+		return row
+	}
+
+	ret := make(Row, len(row))
+	for k, v := range row {
+		if !options.isIgnored(table, k) {
+			ret[k] = v
+		}
+	}
+
+	return ret
+}
+
+// rowKey returns the key identifying row formatted as column=value pairs.
+// If pk is empty, the entire row is used as the key.
+func rowKey(row Row, pk []string) string {
+	if len(pk) == 0 {
+		return formatRow(row)
+	}
+
+	parts := make([]string, len(pk))
+	for i, col := range pk {
+		parts[i] = col + "=" + formatValue(row[col])
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func changedColumns(a, b Row) []string {
+	var cols []string
+
+	for _, col := range slices.Sorted(maps.Keys(a)) {
+		if bv, ok := b[col]; !ok || !reflect.DeepEqual(a[col], bv) {
+			cols = append(cols, col)
+		}
+	}
+
+	for _, col := range slices.Sorted(maps.Keys(b)) {
+		if _, ok := a[col]; !ok {
+			cols = append(cols, col)
+		}
+	}
+
+	return cols
+}
+
+// formatRow formats row as a sequence of column: value pairs ordered by
+// column name.
+func formatRow(row Row) string {
+	parts := make([]string, 0, len(row))
+	for _, col := range slices.Sorted(maps.Keys(row)) {
+		parts = append(parts, col+": "+formatValue(row[col]))
+	}
+
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func formatValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(b)
+}
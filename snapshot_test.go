@@ -0,0 +1,155 @@
+package pgxephemeraltest_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	users := func(rows ...pgxephemeraltest.Row) *pgxephemeraltest.DataSnapshot {
+		return &pgxephemeraltest.DataSnapshot{Tables: map[string]*pgxephemeraltest.TableSnapshot{
+			"users": {
+				Name:        "users",
+				Columns:     []string{"id", "name", "updated_at"},
+				ColumnTypes: []string{"integer", "text", "timestamp with time zone"},
+				PrimaryKey:  []string{"id"},
+				Rows:        rows,
+			},
+		}}
+	}
+
+	t.Run("it reports added, removed and changed rows", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		a := users(
+			pgxephemeraltest.Row{"id": int64(1), "name": "alice", "updated_at": "2024-01-01T00:00:00+00:00"},
+			pgxephemeraltest.Row{"id": int64(2), "name": "bob", "updated_at": "2024-01-01T00:00:00+00:00"},
+		)
+		b := users(
+			pgxephemeraltest.Row{"id": int64(1), "name": "alicia", "updated_at": "2024-01-02T00:00:00+00:00"},
+			pgxephemeraltest.Row{"id": int64(3), "name": "carol", "updated_at": "2024-01-02T00:00:00+00:00"},
+		)
+
+		// Act
+		d := pgxephemeraltest.Diff(a, b, pgxephemeraltest.IgnoreColumns("users.updated_at"))
+
+		// Assert
+		require.False(t, d.Empty())
+		require.Len(t, d.Tables, 1)
+
+		td := d.Tables[0]
+		assert.Equal(t, []pgxephemeraltest.Row{{"id": int64(2), "name": "bob"}}, td.Removed)
+		assert.Equal(t, []pgxephemeraltest.Row{{"id": int64(3), "name": "carol"}}, td.Added)
+		require.Len(t, td.Changed, 1)
+		assert.Equal(t, "id=1", td.Changed[0].Key)
+		assert.Equal(t, []string{"name"}, td.Changed[0].Columns)
+
+		assert.Equal(t, `table users:
+  - {id: 2, name: "bob"}
+  + {id: 3, name: "carol"}
+  ~ id=1:
+      name: "alice" -> "alicia"`, d.String())
+	})
+
+	t.Run("it reports no difference for equal snapshots", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		a := users(pgxephemeraltest.Row{"id": int64(1), "name": "alice", "updated_at": "2024-01-01T00:00:00+00:00"})
+		b := users(pgxephemeraltest.Row{"id": int64(1), "name": "alice", "updated_at": "2024-01-02T00:00:00+00:00"})
+
+		// Act
+		d := pgxephemeraltest.Diff(a, b, pgxephemeraltest.IgnoreColumns("updated_at"))
+
+		// Assert
+		assert.True(t, d.Empty())
+		pgxephemeraltest.AssertNoDiff(t, a, b, pgxephemeraltest.IgnoreColumns("updated_at"))
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	f, err := pgxephemeraltest.NewPoolFactory(t.Context(), testutil.PoolConfig(t), testutil.NewKVMigrator())
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	t.Run("it captures table contents within a transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+		_, err := tx.Exec(t.Context(), "INSERT INTO kv (key, value) VALUES ('b', '2'), ('a', '1')")
+		require.NoError(t, err)
+
+		before := pgxephemeraltest.TakeSnapshot(t, tx)
+
+		// Act
+		_, err = tx.Exec(t.Context(), "UPDATE kv SET value = '3' WHERE key = 'a'")
+		require.NoError(t, err)
+
+		after := pgxephemeraltest.TakeSnapshot(t, tx, "kv")
+
+		// Assert
+		assert.Equal(t, []string{"key"}, before.Tables["kv"].PrimaryKey)
+		assert.Equal(t, []pgxephemeraltest.Row{
+			{"key": "a", "value": "1"},
+			{"key": "b", "value": "2"},
+		}, before.Tables["kv"].Rows)
+
+		d := pgxephemeraltest.Diff(before, after)
+		require.Len(t, d.Tables, 1)
+		require.Len(t, d.Tables[0].Changed, 1)
+		assert.Equal(t, `key="a"`, d.Tables[0].Changed[0].Key)
+	})
+
+	t.Run("it orders rows of tables without a primary key by contents", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+		_, err := tx.Exec(t.Context(), `CREATE TABLE events (payload json, amount numeric);
+INSERT INTO events VALUES ('{"n": 2}', 12345678901234567890.5), ('{"n": 1}', 1.50)`)
+		require.NoError(t, err)
+
+		// Act
+		s := pgxephemeraltest.TakeSnapshot(t, tx, "events")
+
+		// Assert
+		assert.Equal(t, []pgxephemeraltest.Row{
+			{"payload": map[string]any{"n": int64(1)}, "amount": json.Number("1.50")},
+			{"payload": map[string]any{"n": int64(2)}, "amount": json.Number("12345678901234567890.5")},
+		}, s.Tables["events"].Rows)
+	})
+
+	t.Run("it snapshots tables with a column named after the row alias", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := pgxephemeraltest.NewTxFactory(f.Pool(t)).Tx(t)
+
+		_, err := tx.Exec(t.Context(), `CREATE TABLE marks (t text, n int);
+INSERT INTO marks VALUES ('b', 1), ('a', 2)`)
+		require.NoError(t, err)
+
+		// Act
+		s := pgxephemeraltest.TakeSnapshot(t, tx, "marks")
+
+		// Assert
+		assert.Equal(t, []pgxephemeraltest.Row{
+			{"t": "b", "n": int64(1)},
+			{"t": "a", "n": int64(2)},
+		}, s.Tables["marks"].Rows)
+	})
+}
@@ -0,0 +1,21 @@
+--
+-- PostgreSQL database dump
+--
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SELECT pg_catalog.set_config('search_path', '', false);
+
+CREATE TABLE public.kv (
+    key text NOT NULL,
+    value text NOT NULL
+);
+
+ALTER TABLE public.kv OWNER TO app;
+
+ALTER TABLE ONLY public.kv
+    ADD CONSTRAINT kv_pkey PRIMARY KEY (key);
+
+--
+-- PostgreSQL database dump complete
+--
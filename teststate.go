@@ -0,0 +1,79 @@
+package pgxephemeraltest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+// testStates holds per-test state shared by all pools and transactions
+// handed to the same test.
+var testStates sync.Map //nolint:gochecknoglobals
+
+// testState is the state attached to a single test.
+type testState struct {
+	log *queryLog
+
+	// cleanupTimeout is the cleanup timeout of the factory the state
+	// was created by.
+	cleanupTimeout time.Duration
+
+	// captures are configs of ephemeral databases with change
+	// capture installed.
+	captures []*pgx.ConnConfig
+	mu       sync.Mutex
+}
+
+// stateFor returns the state of the tb test, creating it on first use.
+//
+// The state is flushed and forgotten once the test is complete. It is
+// important to call stateFor before registering any other cleanups, so
+// that the state outlives pools and transactions of the test.
+func stateFor(tb internaltesting.TB, options factoryOptions) *testState {
+	tb.Helper()
+
+	if s, ok := testStates.Load(tb); ok {
+		return s.(*testState) //nolint:forcetypeassert // only *testState is stored.
+	}
+
+	s := &testState{
+		log:            newQueryLog(options.redactQueryArgs),
+		cleanupTimeout: options.cleanupTimeout,
+		captures:       nil,
+		mu:             sync.Mutex{},
+	}
+	if actual, loaded := testStates.LoadOrStore(tb, s); loaded {
+		return actual.(*testState) //nolint:forcetypeassert // only *testState is stored.
+	}
+
+	tb.Cleanup(func() {
+		testStates.Delete(tb)
+
+		if options.verboseQueryLog || tb.Failed() {
+			s.log.flush(tb)
+		}
+	})
+
+	return s
+}
+
+// addCapture records an ephemeral database with change capture installed.
+func (s *testState) addCapture(config *pgx.ConnConfig) {
+	s.mu.Lock()
+	s.captures = append(s.captures, config)
+	s.mu.Unlock()
+}
+
+// cleanupTimeoutFor returns the cleanup timeout of the factory the tb test
+// got its pools or transactions from, or DefaultCleanupTimeout if there
+// is none.
+func cleanupTimeoutFor(tb internaltesting.TB) time.Duration {
+	if s, ok := testStates.Load(tb); ok {
+		return s.(*testState).cleanupTimeout //nolint:forcetypeassert // only *testState is stored.
+	}
+
+	return DefaultCleanupTimeout
+}
@@ -0,0 +1,36 @@
+package pgxephemeraltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupTimeoutFor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it uses the timeout of the factory of the test", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		options := factoryOptions{cleanupTimeout: time.Minute} //nolint:exhaustruct // only the timeout matters.
+		stateFor(t, options)
+
+		// Act
+		timeout := cleanupTimeoutFor(t)
+
+		// Assert
+		assert.Equal(t, time.Minute, timeout)
+	})
+
+	t.Run("it defaults to DefaultCleanupTimeout", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		timeout := cleanupTimeoutFor(t)
+
+		// Assert
+		assert.Equal(t, DefaultCleanupTimeout, timeout)
+	})
+}
@@ -19,6 +19,7 @@ import (
 // rolled back and the database state is reset to its initial state.
 type TxFactory struct {
 	executor Executor
+	tracer   *connQueryTracer
 	options  factoryOptions
 }
 
@@ -33,7 +34,7 @@ func NewTxFactory(executor Executor, opts ...FactoryOption) *TxFactory {
 
 	options.defaults()
 
-	return &TxFactory{executor: executor, options: options}
+	return &TxFactory{executor: executor, tracer: nil, options: options}
 }
 
 // NewTxFactoryFromConnString creates a new connection pool from the provided connection string and
@@ -41,17 +42,29 @@ func NewTxFactory(executor Executor, opts ...FactoryOption) *TxFactory {
 //
 // A TxFactory instance is returned along with a cleanup function that
 // should be called after testing is complete.
+//
+// Unlike NewTxFactory, the created pool records queries executed within each
+// transaction into a per-test query log.
 func NewTxFactoryFromConnString(
 	ctx context.Context,
 	connString string,
 	opts ...FactoryOption,
 ) (*TxFactory, func(), error) {
-	pool, err := pgxpool.New(ctx, connString)
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pgxephemeraltest: failed to parse connection string: %w", err)
+	}
+
+	tracer := newConnQueryTracer()
+	config.ConnConfig.Tracer = composeTracer(config.ConnConfig.Tracer, tracer)
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return nil, nil, fmt.Errorf("pgxephemeraltest: failed to create connection pool: %w", err)
 	}
 
 	f := NewTxFactory(pool, opts...)
+	f.tracer = tracer
 
 	return f, pool.Close, nil
 }
@@ -61,9 +74,18 @@ func NewTxFactoryFromConnString(
 // state is reset to its initial state.
 //
 // If it fails to start a new transaction a panic is raised.
+//
+// If the factory owns the underlying pool (see NewTxFactoryFromConnString),
+// queries executed within the transaction are buffered and written to the test
+// log if the test fails (or always, see WithVerboseQueryLog).
 func (f TxFactory) Tx(tb internaltesting.TB) pgx.Tx {
 	tb.Helper()
 
+	var state *testState
+	if f.tracer != nil {
+		state = stateFor(tb, f.options)
+	}
+
 	// ReadCommitted is the default isolation level in Postgres, however,
 	// it might be overridden by the database configuration. We need to ensure
 	// that the transaction isolation level doesn't allow dirty writes.
@@ -86,5 +108,11 @@ func (f TxFactory) Tx(tb internaltesting.TB) pgx.Tx {
 		}
 	})
 
+	// Detach the connection before the rollback above, as the connection
+	// is returned to the pool and might be reused by another test.
+	if state != nil {
+		tb.Cleanup(f.tracer.attach(tx.Conn(), state.log))
+	}
+
 	return tx
 }
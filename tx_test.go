@@ -23,6 +23,7 @@ func TestTxFactory(t *testing.T) {
 		testutil.NewKVMigrator(),
 	)
 	require.NoError(t, err)
+	t.Cleanup(pf.Close)
 
 	txf := pgxephemeraltest.NewTxFactory(pf.Pool(t))
 
@@ -0,0 +1,280 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+// UpgradeFactory tests migration steps against data shaped by the preceding
+// steps.
+//
+// It builds a template database for each version of the schema, where
+// version v is the database with the first v steps applied. Each template
+// is created as a copy of the previous one, so a step is applied only once
+// for all tests, and templates are reused across test runs until the hashes
+// of the steps change.
+type UpgradeFactory struct {
+	m     *dbmanager.DBManager
+	steps []Migrator
+	// factories create databases by versions.
+	factories []*PoolFactory
+}
+
+// NewUpgradeFactory creates a new UpgradeFactory instance for the ordered
+// migration steps, building templates of all versions.
+//
+// Factory options apply to the templates of all versions: extensions are
+// installed and privileges of the application role set by WithAppConfig
+// are arranged in the version 0 template, the stale template check runs each
+// step on top of the previous version, and the templates of all versions
+// are kept by template garbage collection.
+func NewUpgradeFactory(
+	ctx context.Context,
+	config *pgxpool.Config,
+	steps []Migrator,
+	opts ...FactoryOption,
+) (_ *UpgradeFactory, err error) {
+	var options factoryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	options.defaults()
+
+	m, err := dbmanager.New(ctx, config, options.managerOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize upgrade factory: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			m.Close()
+		}
+	}()
+
+	if err := ensureRoles(ctx, m, options); err != nil {
+		return nil, err
+	}
+
+	f := UpgradeFactory{m: m, steps: steps, factories: make([]*PoolFactory, len(steps)+1)}
+
+	// Version 0 installs the extensions and arranges privileges of
+	// the application role, so all steps run in the same setup.
+	setup, err := options.extensionsMigrator(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize upgrade factory: %w", err)
+	}
+
+	setup = options.withAppPrivileges(setup)
+
+	b, err := newBudget(ctx, m, config, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var base string
+
+	for v := range f.factories {
+		vm := versionMigrator{
+			step:    setup,
+			version: v,
+			hash:    versionHash(setup, steps[:v]),
+			lineage: options.templateLineage,
+		}
+		if v > 0 {
+			vm.step = steps[v-1]
+		}
+
+		template := dbmanager.TemplateName(config.ConnConfig, vm, options.createOptions.Identity())
+
+		if err := m.InitFrom(ctx, vm, base, template); err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to initialize version %d template: %w", v, err)
+		}
+
+		if err := checkTemplate(ctx, m, vm, base, template, options.staleTemplatePolicy); err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to check version %d template: %w", v, err)
+		}
+
+		fingerprint, err := m.Fingerprint(ctx, template)
+		if err != nil {
+			return nil, fmt.Errorf("pgxephemeraltest: failed to fingerprint version %d: %w", v, err)
+		}
+
+		f.factories[v] = &PoolFactory{
+			m:           m,
+			config:      config.Copy(),
+			template:    template,
+			fingerprint: fingerprint,
+			options:     options,
+			budget:      b,
+			borrowed:    true,
+		}
+		base = template
+	}
+
+	templates := make([]string, len(f.factories))
+	for i, vf := range f.factories {
+		templates[i] = vf.template
+	}
+
+	if err := collectTemplates(ctx, m, options, templates...); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Close closes maintenance connections shared by the factories of all
+// versions.
+func (f *UpgradeFactory) Close() { f.m.Close() }
+
+// Len returns the number of migration steps.
+func (f *UpgradeFactory) Len() int { return len(f.steps) }
+
+// Version returns a factory of databases with the first v steps applied.
+// Version 0 is an empty database, except for extensions installed by
+// WithExtensions and privileges arranged for WithAppConfig, and version
+// Len() is the database with all steps applied.
+//
+// The factories share the maintenance connections of the UpgradeFactory,
+// so closing them is a no-op, close the UpgradeFactory instead.
+func (f *UpgradeFactory) Version(v int) *PoolFactory {
+	if v < 0 || v >= len(f.factories) {
+		panic(fmt.Sprintf("pgxephemeraltest: version %d is out of range [0, %d]", v, len(f.steps)))
+	}
+
+	return f.factories[v]
+}
+
+// Step returns a database prepared for testing the step with index n.
+//
+// The database is at version n, that is, with the steps preceding the
+// step n applied. Seed it through the pool and call Apply to run the step.
+func (f *UpgradeFactory) Step(tb internaltesting.TB, n int) *UpgradeStep {
+	tb.Helper()
+
+	if n < 0 || n >= len(f.steps) {
+		tb.Fatalf("pgxephemeraltest: step %d is out of range [0, %d)", n, len(f.steps))
+
+		return nil
+	}
+
+	vf := f.factories[n]
+
+	return &UpgradeStep{
+		tb:      tb,
+		pool:    vf.Pool(tb),
+		admin:   vf.config.ConnConfig,
+		step:    f.steps[n],
+		n:       n,
+		applied: false,
+	}
+}
+
+// UpgradeStep is a database prepared for testing a single migration step.
+type UpgradeStep struct {
+	tb   internaltesting.TB
+	pool *pgxpool.Pool
+	// admin is the config of the role migrating the database.
+	admin   *pgx.ConnConfig
+	step    Migrator
+	n       int
+	applied bool
+}
+
+// Pool returns the pool connected to the database.
+func (s *UpgradeStep) Pool() *pgxpool.Pool { return s.pool }
+
+// Apply applies the step to the database. It fails the test on error.
+func (s *UpgradeStep) Apply() {
+	s.tb.Helper()
+
+	if s.applied {
+		s.tb.Fatalf("pgxephemeraltest: step %d is already applied", s.n)
+
+		return
+	}
+
+	ctx := s.tb.Context()
+
+	// The step is applied by the role migrating templates, which differs
+	// from the role of the pool if WithAppConfig is used.
+	config := s.admin.Copy()
+	config.Database = s.pool.Config().ConnConfig.Database
+
+	err := withConn(ctx, config, func(conn *pgx.Conn) error { return s.step.Migrate(ctx, conn) })
+	assertNoError(s.tb, err, "pgxephemeraltest: failed to apply step "+strconv.Itoa(s.n))
+
+	s.applied = true
+}
+
+// versionMigrator applies the last step of a version on top of the template
+// of the previous version.
+type versionMigrator struct {
+	// step is the last step of the version. For version 0, it sets up
+	// the database as factory options require, and is nil if there is
+	// nothing to set up.
+	step    Migrator
+	version int
+	// hash identifies all steps of the version.
+	hash string
+	// lineage is the lineage set by WithTemplateLineage, if any.
+	lineage string
+}
+
+func (m versionMigrator) Hash() string { return m.hash }
+
+// Lineage returns the lineage of the version template, which is the version
+// and the lineage set by WithTemplateLineage or, if none is set, the lineage
+// of its last step. Templates of versions without a lineage have none,
+// including version 0 unless WithTemplateLineage is set.
+func (m versionMigrator) Lineage() string {
+	l := m.lineage
+	if l == "" {
+		if lm, ok := m.step.(dbmanager.Lineager); ok {
+			l = lm.Lineage()
+		}
+	}
+
+	if l == "" {
+		return ""
+	}
+
+	return "upgrade/" + strconv.Itoa(m.version) + "/" + l
+}
+
+func (m versionMigrator) Migrate(ctx context.Context, conn *pgx.Conn) error {
+	if m.step == nil {
+		return nil
+	}
+
+	return m.step.Migrate(ctx, conn) //nolint:wrapcheck // wrapped by DBManager.
+}
+
+// versionHash returns a hash identifying the sequence of steps applied
+// on top of the setup of version 0, which may be nil.
+func versionHash(setup Migrator, steps []Migrator) string {
+	h := sha256.New()
+	h.Write([]byte("upgrade"))
+
+	if setup != nil {
+		hash := setup.Hash()
+		h.Write([]byte("setup:" + strconv.Itoa(len(hash)) + ":" + hash))
+	}
+
+	for _, step := range steps {
+		hash := step.Hash()
+		h.Write([]byte(strconv.Itoa(len(hash)) + ":" + hash))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,122 @@
+package pgxephemeraltest_test
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestUpgradeFactory(t *testing.T) {
+	t.Parallel()
+
+	suffix := strconv.FormatInt(rand.Int64(), 10) // #nosec G404
+	steps := []pgxephemeraltest.Migrator{
+		testutil.NewMigrator("CREATE TABLE users (id INT PRIMARY KEY, name TEXT NOT NULL)", "users-1-"+suffix),
+		testutil.NewMigrator(`
+ALTER TABLE users ADD COLUMN first_name TEXT;
+UPDATE users SET first_name = split_part(name, ' ', 1);
+ALTER TABLE users ALTER COLUMN first_name SET NOT NULL;`, "users-2-"+suffix),
+	}
+
+	f, err := pgxephemeraltest.NewUpgradeFactory(t.Context(), testutil.PoolConfig(t), steps)
+	require.NoError(t, err)
+	t.Cleanup(f.Close)
+
+	t.Run("it applies a step to data seeded at the previous version", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		step := f.Step(t, 1)
+
+		_, err := step.Pool().Exec(t.Context(), "INSERT INTO users (id, name) VALUES (1, 'Ada Lovelace')")
+		require.NoError(t, err)
+
+		// Act
+		step.Apply()
+
+		// Assert
+		var firstName string
+
+		err = step.Pool().QueryRow(t.Context(), "SELECT first_name FROM users WHERE id = 1").Scan(&firstName)
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", firstName)
+	})
+
+	t.Run("it keeps connections of the upgrade factory on closing a version", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		version := f.Version(0)
+
+		// Act
+		version.Close()
+
+		// Assert
+		require.NoError(t, version.Pool(t).Ping(t.Context()))
+		require.NoError(t, f.Version(1).Pool(t).Ping(t.Context()))
+	})
+
+	t.Run("it builds templates for each version once", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		pool := f.Version(f.Len()).Pool(t)
+
+		// Assert
+		_, err := pool.Exec(t.Context(), "INSERT INTO users (id, name, first_name) VALUES (1, 'Ada', 'Ada')")
+		require.NoError(t, err)
+
+		for _, step := range steps {
+			//nolint:forcetypeassert // steps are test migrators.
+			assert.Equal(t, int32(1), step.(*testutil.Migrator).Calls())
+		}
+
+		assert.NotEqual(t, f.Version(0).Template(), f.Version(1).Template())
+	})
+
+	t.Run("it applies factory options to templates of all versions", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		config := testutil.PoolConfig(t)
+		lineage := "upgrade-options-" + suffix
+		steps := []pgxephemeraltest.Migrator{
+			testutil.NewMigrator("CREATE TABLE users (id INT PRIMARY KEY)", "options-1-"+suffix),
+			testutil.NewMigrator("ALTER TABLE users ADD COLUMN name TEXT", "options-2-"+suffix),
+		}
+
+		// Act
+		f, err := pgxephemeraltest.NewUpgradeFactory(
+			t.Context(),
+			config,
+			steps,
+			pgxephemeraltest.WithTemplateLineage(lineage),
+			pgxephemeraltest.WithStaleTemplateCheck(pgxephemeraltest.StaleTemplateRebuild),
+		)
+		require.NoError(t, err)
+		t.Cleanup(f.Close)
+
+		// Assert
+		pool := f.Version(0).Pool(t)
+
+		for v := range f.Len() + 1 {
+			var actual string
+
+			err := pool.QueryRow(
+				t.Context(),
+				`SELECT shobj_description(oid, 'pg_database')::jsonb->>'lineage'
+FROM pg_database
+WHERE datname = $1`,
+				f.Version(v).Template(),
+			).Scan(&actual)
+			require.NoError(t, err)
+			assert.Equal(t, config.ConnConfig.User+"/upgrade/"+strconv.Itoa(v)+"/"+lineage, actual)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package pgxephemeraltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/catalog"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/dbmanager"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/internaltesting"
+)
+
+// SchemaDiff is the difference between two database schemas, covering
+// schemas, tables, columns, views, sequences, indexes, constraints,
+// functions, triggers, enums and domains.
+type SchemaDiff = catalog.SchemaDiff
+
+// MigrationReport is the result of VerifyMigration.
+type MigrationReport struct {
+	// RerunErr is the error of running the up migration for the second time.
+	RerunErr error
+
+	// Rerun is the schema change made by running the up migration for
+	// the second time, empty for idempotent migrations.
+	Rerun *SchemaDiff
+
+	// DownErr is the error of running the down migration.
+	DownErr error
+
+	// Down is the difference between the schema before running the up
+	// migration and the schema after running the down migration, empty
+	// for reversible migrations. It is nil if no down migration is given.
+	Down *SchemaDiff
+
+	// ReapplyErr is the error of running the up migration after the down
+	// migration.
+	ReapplyErr error
+
+	// Reapply is the difference between the schema after running the up
+	// migration and the schema after running the down and up migrations.
+	// It is nil if no down migration is given.
+	Reapply *SchemaDiff
+}
+
+// OK reports whether all checks passed.
+func (r *MigrationReport) OK() bool {
+	return r.RerunErr == nil && r.DownErr == nil && r.ReapplyErr == nil &&
+		emptyDiff(r.Rerun) && emptyDiff(r.Down) && emptyDiff(r.Reapply)
+}
+
+// String returns a human-readable summary of failed checks.
+func (r *MigrationReport) String() string {
+	var b strings.Builder
+
+	report := func(check string, err error, d *SchemaDiff) {
+		switch {
+		case err != nil:
+			fmt.Fprintf(&b, "%s: %v\n", check, err)
+		case !emptyDiff(d):
+			fmt.Fprintf(&b, "%s:\n%s\n", check, d)
+		}
+	}
+
+	report("up migration is not idempotent", r.RerunErr, r.Rerun)
+	report("down migration does not revert the schema", r.DownErr, r.Down)
+	report("up migration does not apply cleanly after down migration", r.ReapplyErr, r.Reapply)
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func emptyDiff(d *SchemaDiff) bool { return d == nil || d.Empty() }
+
+// VerifyMigration checks that the up migration is idempotent and, if down
+// is not nil, that the down migration reverts it.
+//
+// The up migration is run twice on a fresh database, the second run must
+// succeed without changing the schema. On another fresh database, the up,
+// down and up migrations are run in sequence: the down migration must
+// restore the initial schema, and running the up migration once again must
+// produce the same schema as the first run.
+//
+// Failed checks are reported in the returned report, errors are returned
+// only if the checks cannot be run, e.g., if the first run of the up
+// migration fails.
+func VerifyMigration(
+	ctx context.Context,
+	config *pgxpool.Config,
+	up, down Migrator,
+) (*MigrationReport, error) {
+	m, err := dbmanager.New(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize database manager: %w", err)
+	}
+	defer m.Close()
+
+	empty := versionMigrator{step: nil, version: 0, hash: versionHash(nil, nil), lineage: ""}
+	template := dbmanager.TemplateName(config.ConnConfig, empty)
+
+	if err := m.Init(ctx, empty, template); err != nil {
+		return nil, fmt.Errorf("pgxephemeraltest: failed to initialize empty template: %w", err)
+	}
+
+	//nolint:exhaustruct // checks are filled in below.
+	report := MigrationReport{}
+
+	err = withFreshDB(ctx, m, config, template, func(conn *pgx.Conn) error {
+		first, err := migrateAndDescribe(ctx, conn, up)
+		if err != nil {
+			return fmt.Errorf("run up migration: %w", err)
+		}
+
+		second, err := migrateAndDescribe(ctx, conn, up)
+		if err != nil {
+			report.RerunErr = err
+			return nil
+		}
+
+		report.Rerun = catalog.DiffSchemas(first, second)
+
+		return nil
+	})
+	if err != nil || down == nil {
+		return &report, err
+	}
+
+	err = withFreshDB(ctx, m, config, template, func(conn *pgx.Conn) error {
+		initial, err := catalog.Describe(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("describe schema: %w", err)
+		}
+
+		applied, err := migrateAndDescribe(ctx, conn, up)
+		if err != nil {
+			return fmt.Errorf("run up migration: %w", err)
+		}
+
+		reverted, err := migrateAndDescribe(ctx, conn, down)
+		if err != nil {
+			report.DownErr = err
+			return nil
+		}
+
+		report.Down = catalog.DiffSchemas(initial, reverted)
+
+		reapplied, err := migrateAndDescribe(ctx, conn, up)
+		if err != nil {
+			report.ReapplyErr = err
+			return nil
+		}
+
+		report.Reapply = catalog.DiffSchemas(applied, reapplied)
+
+		return nil
+	})
+
+	return &report, err
+}
+
+// AssertMigration asserts that the up migration is idempotent and, if down
+// is not nil, that the down migration reverts it. See VerifyMigration.
+func AssertMigration(tb internaltesting.TB, config *pgxpool.Config, up, down Migrator) {
+	tb.Helper()
+
+	report, err := VerifyMigration(tb.Context(), config, up, down)
+	assertNoError(tb, err)
+
+	if !report.OK() {
+		tb.Errorf("pgxephemeraltest: migration checks failed:\n%s", report)
+	}
+}
+
+// withFreshDB runs fn with a connection to a new database created from
+// the template, dropping the database afterwards.
+func withFreshDB(
+	ctx context.Context,
+	m *dbmanager.DBManager,
+	config *pgxpool.Config,
+	template string,
+	fn func(*pgx.Conn) error,
+) (err error) {
+	name, err := randomName(6)
+	if err != nil {
+		return err
+	}
+
+	db, err := m.CreateDB(ctx, template, name)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to create database: %w", err)
+	}
+
+	defer func() {
+		if dropErr := m.DropDB(context.WithoutCancel(ctx), db); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+	}()
+
+	connConfig := config.ConnConfig.Copy()
+	connConfig.Database = db
+
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to connect to database: %w", err)
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if err := fn(conn); err != nil {
+		return fmt.Errorf("pgxephemeraltest: failed to verify migration: %w", err)
+	}
+
+	return nil
+}
+
+func migrateAndDescribe(ctx context.Context, conn *pgx.Conn, m Migrator) (*catalog.Schema, error) {
+	if err := m.Migrate(ctx, conn); err != nil {
+		return nil, err //nolint:wrapcheck // reported as is.
+	}
+
+	s, err := catalog.Describe(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("describe schema: %w", err)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,54 @@
+package pgxephemeraltest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.segfaultmedaddy.com/pgxephemeraltest"
+	"go.segfaultmedaddy.com/pgxephemeraltest/internal/testutil"
+)
+
+func TestVerifyMigration(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it passes idempotent and reversible migrations", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		up := testutil.NewMigrator(`
+CREATE TABLE IF NOT EXISTS accounts (id INT PRIMARY KEY, email TEXT NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_email_key ON accounts (email);`, "verify-up")
+		down := testutil.NewMigrator("DROP TABLE accounts", "verify-down")
+
+		// Act
+		report, err := pgxephemeraltest.VerifyMigration(t.Context(), testutil.PoolConfig(t), up, down)
+
+		// Assert
+		require.NoError(t, err)
+		assert.True(t, report.OK(), report.String())
+	})
+
+	t.Run("it reports non-idempotent migrations and incomplete down migrations", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		up := testutil.NewMigrator(`
+CREATE TABLE accounts (id INT PRIMARY KEY, email TEXT NOT NULL);
+CREATE INDEX accounts_email_idx ON accounts (email);`, "verify-up-broken")
+		down := testutil.NewMigrator("DROP INDEX accounts_email_idx", "verify-down-broken")
+
+		// Act
+		report, err := pgxephemeraltest.VerifyMigration(t.Context(), testutil.PoolConfig(t), up, down)
+
+		// Assert
+		require.NoError(t, err)
+		assert.False(t, report.OK())
+		require.Error(t, report.RerunErr)
+		require.NotNil(t, report.Down)
+		assert.Contains(t, report.String(), "+ table public.accounts")
+		assert.Contains(t, report.String(), "+ column public.accounts.email: text NOT NULL")
+		require.Error(t, report.ReapplyErr)
+	})
+}